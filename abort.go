@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"gio_flicker/stimulus"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/widget"
+	"image/color"
+	"log"
+	"time"
+)
+
+// Stop the running session at once on the user's request and replace the
+// stimulus with a neutral gray of its mean luminance, so the stop is not a
+// flash of its own
+func abortSession(ui *UI, how string) {
+	if !ui.engine.Running() {
+		return
+	}
+	state := ui.engine.State()
+	stopTicker(ui)
+	mean := framesMean(frameImages(ui, state.Item, ui.areaSize))
+	if state.Blank {
+		if fill := blankFill(ui); fill != nil {
+			mean = *fill
+		}
+	}
+	gray := stimulus.Gray(stimulus.Luminance(mean))
+	ui.aborted = &gray
+	step := ""
+	if state.Step >= 0 {
+		step = fmt.Sprintf(" in schedule step %d", state.Step+1)
+	}
+	log.Printf("Session aborted by the user with %s%s after %s", how, step,
+		time.Since(ui.sessionStart).Round(time.Second))
+}
+
+// A clicked button keeps the keyboard focus and would take Space, so
+// while a session runs the focus is only left to editors
+func releaseButtonFocus(gtx layout.Context, ui *UI) {
+	if ui.engine.Running() && !editorFocused(gtx, ui) {
+		gtx.Execute(key.FocusCmd{})
+	}
+}
+
+// Report whether an editor has the keyboard focus, which takes Space and
+// Enter as text
+func editorFocused(gtx layout.Context, ui *UI) bool {
+	editors := []*widget.Editor{
+		&ui.rateEditor, &ui.dutyEditor, &ui.scheduleEditor, &ui.refreshEditor, &ui.fadeEditor,
+		&ui.frameEditor, &ui.patternEditor, &ui.distanceEditor, &ui.densityEditor,
+		&ui.blankEditor, &ui.fixationEditor, &ui.picker.nameEditor,
+	}
+	for _, ed := range editors {
+		if gtx.Focused(ed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fill the stimulus area with the gray of an aborted session
+func drawAborted(gtx layout.Context, gray color.NRGBA) layout.Dimensions {
+	paint.FillShape(gtx.Ops, gray, clip.Rect{Max: gtx.Constraints.Max}.Op())
+	return layout.Dimensions{Size: gtx.Constraints.Max}
+}
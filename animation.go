@@ -1,257 +1,252 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"gioui.org/app"
-	"os"
+	"gio_flicker/config"
+	"gio_flicker/engine"
+	"gio_flicker/schedule"
+	"log"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func (ui *UI) FlipImage(w *app.Window) {
-	if 1 == ui.mainImage {
-		ui.mainImage = 2
-	} else {
-		ui.mainImage = 1
-	}
-	w.Invalidate()
-}
-
-func changeRate(ui *UI, w *app.Window) {
-	text := ui.rateEditor.Text()
-	var newRate int
-	if text != "" {
-		_, err := fmt.Sscanf(text, "%d", &newRate)
+// Hand the rate, duty cycle and waveform to the engine. A rate or duty
+// cycle the engine rejects is shown next to the editors and the previous
+// values stay in effect.
+func changeRate(ui *UI) {
+	rate, err := parseRate(ui.rateEditor.Text(), ui.rateUnit)
+	if err == nil {
+		err = ui.engine.SetRate(rate)
 		if err != nil {
-			newRate = 1
+			err = fmt.Errorf("flicker rate %s: %w", rate, err)
 		}
 	}
-	if newRate <= 0 || newRate >= 100 {
-		newRate = 1
+	ui.rateErr = err
+	duty, err := parseDuty(ui.dutyEditor.Text())
+	if err == nil {
+		err = ui.engine.SetDutyCycle(duty)
 	}
-	ui.flipRate.Store(int32(newRate))
-	if ui.isTickerRunning.Load() {
-		stopTicker(ui)
-		<-ui.cleanedTicker
-		startTicker(ui, w)
+	ui.dutyErr = err
+	ui.engine.SetWaveform(ui.waveform)
+	if ui.rateErr != nil || ui.dutyErr != nil {
+		log.Printf("Flicker rate not changed, %v", errors.Join(ui.rateErr, ui.dutyErr))
+		return
 	}
+	log.Printf("Flicker rate set to %s", describeItem(engine.ScheduleItem{
+		FlickeringRate: rate,
+		DutyCycle:      duty,
+		Waveform:       ui.waveform,
+	}))
+	ui.safety.stale = true
+	ui.engine.Restart()
 }
 
-func calculateRate(flipsPerSecond int) (int, error) {
-	if flipsPerSecond <= 0 {
-		return 0, fmt.Errorf("flips per second must be positive, got: %d", flipsPerSecond)
+// Parse the text of the rate editor in unit. An empty text is the default
+// of one flip per second.
+func parseRate(text string, unit engine.RateUnit) (engine.Rate, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return engine.Flips(1), nil
 	}
-	if flipsPerSecond > 100 {
-		return 0, fmt.Errorf("flips per second must be <= 100, got: %d", flipsPerSecond)
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return engine.Rate{}, fmt.Errorf("flicker rate %q is not a number", text)
 	}
-
-	// Convert flips per second to milliseconds between flips
-	// 1000ms / flips = ms per flip
-	return 1000 / flipsPerSecond, nil
+	return engine.Rate{Value: value, Unit: unit}, nil
 }
 
-func startTicker(ui *UI, w *app.Window) {
-	if ui.isTickerRunning.Load() == true {
-		return
-	}
-
+func startTicker(ui *UI) {
 	// Check if we're using schedule mode
-	if ui.useSchedule && len(ui.schedule) > 0 {
-		startScheduledTicker(ui, w)
+	if ui.useSchedule {
+		ui.engine.SetSchedule(ui.schedule)
+	} else {
+		ui.engine.SetSchedule(engine.Schedule{})
+	}
+	if ui.frameLocked {
+		ui.engine.SetFrameLocked(refreshRate(ui))
+	} else {
+		ui.engine.SetFrameLocked(0)
+	}
+	ui.engine.SetRamp(engine.Ramp{Duration: fadeDuration(ui), Frequency: ui.fadeRate})
+	if ui.engine.Running() && ui.engine.State().Finished {
+		// Leave the blank screen held after a finished schedule
+		ui.engine.Stop()
+	}
+	if ui.engine.Running() {
 		return
 	}
-
-	// Normal mode (single rate)
-	rate, err := calculateRate(int(ui.flipRate.Load()))
-	if err != nil {
-		rate = 1
+	if locked, reason, until := ui.limits.Locked(time.Now()); locked {
+		log.Printf("Flicker not started, %s, wait until %s", reason, until.Format(time.DateTime))
+		return
 	}
-	if ui.t == nil {
-		ui.t = time.NewTicker(time.Millisecond * time.Duration(rate))
+	if ui.consent.isOpen {
+		log.Printf("Flicker not started, the health warning has not been acknowledged")
+		return
 	}
-
-	if ui.tickerDone == nil {
-		ui.tickerDone = make(chan bool, 1)
+	if ui.useSchedule && ui.scheduleErr != nil {
+		// Never run a schedule that did not parse
+		log.Printf("Flicker not started, the schedule has errors: %v", ui.scheduleErr)
+		return
 	}
-	ui.isTickerRunning.Store(true)
-
-	go func() {
-		defer func() {
-			close(ui.tickerDone)
-			ui.t.Stop()
-			ui.t = nil
-			ui.tickerDone = nil
-			ui.isTickerRunning.Store(false)
-			ui.cleanedTicker <- true
-		}()
-		for {
-			select {
-			case <-ui.t.C:
-				ui.FlipImage(w)
-
-			case <-ui.tickerDone:
-				return
-			}
+	if ui.useSchedule {
+		if err := loadStepStimuli(ui, ui.schedule); err != nil {
+			log.Printf("Flicker not started, the schedule shows stimuli that can't be loaded: %v", err)
+			ui.imageErr = err
+			return
 		}
-	}()
-}
-
-func startScheduledTicker(ui *UI, w *app.Window) {
-	// Initialize schedule variables
-	ui.currentScheduleIndex = 0
-	ui.scheduleStartTime = time.Now()
-
-	// Start with the first schedule item
-	currentItem := ui.schedule[0]
-
-	var rate int
-	if currentItem.BlankTime > 0 {
-		// This is a blank period, we don't need to flicker
-		rate = 1000 // Just a slow tick to check for schedule transitions
+	}
+	if !safetyApproved(ui) {
+		return
+	}
+	if ui.useSchedule && len(ui.schedule.Items) > 0 {
+		log.Printf("Flicker started with schedule %q", schedule.Format(ui.schedule))
 	} else {
-		var err error
-		rate, err = calculateRate(currentItem.FlickeringRate)
-		if err != nil {
-			rate = 1000
+		log.Printf("Flicker started at %s", describeItem(engine.ScheduleItem{
+			FlickeringRate: ui.engine.Rate(),
+			DutyCycle:      ui.engine.DutyCycle(),
+			Waveform:       ui.engine.Waveform(),
+		}))
+	}
+	if ramp := ui.engine.Ramp(); ramp.Duration > 0 {
+		what := "contrast"
+		if ramp.Frequency {
+			what = "contrast and rate"
 		}
+		log.Printf("Fading the %s in and out over %s", what, ramp.Duration)
 	}
+	ui.aborted = nil
+	ui.sessionStart = time.Now()
+	startAttentionTask(ui, ui.sessionStart)
+	ui.engine.Start()
+}
 
-	if ui.t == nil {
-		ui.t = time.NewTicker(time.Millisecond * time.Duration(rate))
+// refreshRate returns the display refresh rate entered by the user, falling
+// back to the measured rate and finally to 60 Hz.
+func refreshRate(ui *UI) float64 {
+	var hz float64
+	if _, err := fmt.Sscanf(ui.refreshEditor.Text(), "%g", &hz); err == nil && hz >= 1 {
+		return hz
 	}
-
-	if ui.tickerDone == nil {
-		ui.tickerDone = make(chan bool, 1)
+	if hz = ui.refreshMeter.Hz(); hz > 0 {
+		return hz
 	}
-	ui.isTickerRunning.Store(true)
-
-	go func() {
-		defer func() {
-			close(ui.tickerDone)
-			ui.t.Stop()
-			ui.t = nil
-			ui.tickerDone = nil
-			ui.isTickerRunning.Store(false)
-			ui.cleanedTicker <- true
-		}()
-
-		// Create a ticker to check schedule transitions every 100ms
-		scheduleTicker := time.NewTicker(100 * time.Millisecond)
-		defer scheduleTicker.Stop()
-
-		for {
-			select {
-			case <-ui.t.C:
-				// Only flip if we're not in a blank period
-				currentItem := ui.schedule[ui.currentScheduleIndex]
-				if currentItem.BlankTime == 0 {
-					ui.FlipImage(w)
-				}
-
-			case <-scheduleTicker.C:
-				// Check if we need to transition to the next schedule item
-				elapsed := time.Since(ui.scheduleStartTime).Seconds()
-				currentItemDuration := 0
-
-				// Calculate the total duration up to the current item
-				totalDuration := 0
-				for i := 0; i <= ui.currentScheduleIndex; i++ {
-					currentItemDuration = ui.schedule[i].Duration
-					totalDuration += currentItemDuration
-				}
-
-				// Check if we need to move to the next item
-				if elapsed >= float64(totalDuration) {
-					ui.currentScheduleIndex++
-
-					// If we've reached the end of the schedule, start over
-					if ui.currentScheduleIndex >= len(ui.schedule) {
-						ui.currentScheduleIndex = 0
-						ui.scheduleStartTime = time.Now()
-					}
-
-					// Update the ticker rate for the new schedule item
-					newItem := ui.schedule[ui.currentScheduleIndex]
-					var newRate int
-
-					if newItem.BlankTime > 0 {
-						// This is a blank period
-						newRate = 1000 // Slow tick rate for blank periods
-					} else {
-						var err error
-						newRate, err = calculateRate(newItem.FlickeringRate)
-						if err != nil {
-							newRate = 1000
-						}
-					}
-
-					// Update the ticker
-					ui.t.Stop()
-					ui.t = time.NewTicker(time.Millisecond * time.Duration(newRate))
-				}
-
-			case <-ui.tickerDone:
-				return
-			}
-		}
-	}()
+	return 60
 }
 
-// okay so we have error when
-// clicked start, set, stop
-// and then start and set
+// fadeDuration returns the length of the fades entered by the user, 0 for
+// none.
+func fadeDuration(ui *UI) time.Duration {
+	var s float64
+	if _, err := fmt.Sscanf(ui.fadeEditor.Text(), "%g", &s); err != nil || s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
 
 func stopTicker(ui *UI) {
-	if ui.isTickerRunning.Load() && ui.tickerDone != nil {
-		ui.tickerDone <- true
+	ui.engine.Stop()
+	endAttentionTask(ui, time.Now())
+}
+
+// Fade the session out, or stop it at once without a fade or when it is
+// already fading out
+func fadeOutTicker(ui *UI) {
+	if ui.engine.FadeOut() {
+		log.Printf("Flicker fading out over %s, Stop again ends it at once", ui.engine.Ramp().Duration)
+		return
 	}
+	stopTicker(ui)
 }
 
-// Parse schedule text into ScheduleItem structs
+// Parse schedule text into ScheduleItem structs, keeping any errors so they
+// can be shown next to the schedule editor
 func parseSchedule(ui *UI, scheduleText string) {
-	ui.schedule = []ScheduleItem{}
+	ui.schedule, ui.scheduleErr = schedule.Parse(scheduleText, ui.rateUnit)
+}
 
-	// Split by semicolons
-	parts := []string{}
-	for _, part := range strings.Split(scheduleText, ";") {
-		part = strings.TrimSpace(part)
-		if part != "" {
-			parts = append(parts, part)
-		}
+// Parse a duty cycle percentage such as "25" or "25%" into a fraction. An
+// empty text means an even split.
+func parseDuty(text string) (float64, error) {
+	text = strings.TrimSuffix(strings.TrimSpace(text), "%")
+	if text == "" {
+		return 0.5, nil
+	}
+	percent, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("duty cycle %q is not a number", text)
 	}
+	duty := percent / 100
+	return duty, engine.ValidateDutyCycle(duty)
+}
 
-	for _, part := range parts {
-		// Check if it's a blank screen time or a flickering period
-		if !strings.Contains(part, "-") {
-			// This is a blank screen time
-			var blankTime int
-			_, err := fmt.Sscanf(part, "%d", &blankTime)
-			if err == nil && blankTime > 0 {
-				ui.schedule = append(ui.schedule, ScheduleItem{
-					Duration:       blankTime,
-					FlickeringRate: 0,
-					BlankTime:      blankTime,
-				})
-			}
-		} else {
-			// This is a flickering period
-			var duration, rate int
-			_, err := fmt.Sscanf(part, "%d-%d", &duration, &rate)
-			if err == nil && duration > 0 && rate > 0 {
-				ui.schedule = append(ui.schedule, ScheduleItem{
-					Duration:       duration,
-					FlickeringRate: rate,
-					BlankTime:      0,
-				})
-			}
-		}
+// Format a duty cycle fraction as a percentage, e.g. "25%"
+func formatDuty(duty float64) string {
+	return strconv.FormatFloat(duty*100, 'f', -1, 64) + "%"
+}
+
+// Describe a schedule item for the log, e.g. "40 Hz (80 flips/s) at 50%
+// duty cycle, square wave" followed by the stimulus of the step
+func describeItem(item engine.ScheduleItem) string {
+	rate := item.FlickeringRate.String()
+	if item.Sweep != engine.NoSweep {
+		rate = fmt.Sprintf("%s sweep from %s to %s", item.Sweep, item.FlickeringRate, item.EndRate)
+	}
+	desc := fmt.Sprintf("%s at %s duty cycle, %s wave", rate, formatDuty(item.DutyCycle), item.Waveform)
+	if item.Stimulus != "" {
+		desc += ", showing " + item.Stimulus
 	}
+	return desc
 }
 
-// Save schedule to file
+// Save schedule to file, or to the library if it was picked from there.
+// Schedules in the old syntax are rewritten in the schedule language first
+// so their units are spelled out. JSON and YAML documents keep their
+// metadata and get the new steps.
 func saveSchedule(ui *UI) {
+	if ui.scheduleErr != nil {
+		return
+	}
 	scheduleText := ui.scheduleEditor.Text()
-	err := os.WriteFile("schedule.txt", []byte(scheduleText), 0644)
+	if schedule.IsLegacy(scheduleText) {
+		scheduleText = schedule.Format(ui.schedule)
+		ui.scheduleEditor.SetText(scheduleText)
+	}
+	if ui.picker.selected != "" {
+		// A schedule picked from the library is saved back to the library,
+		// presets have to be duplicated first
+		doc := currentDocument(ui)
+		err := doc.SetText(scheduleText, ui.rateUnit)
+		if err == nil {
+			err = ui.library.Save(doc)
+		}
+		if err != nil {
+			fmt.Println("Error saving schedule:", err)
+			ui.picker.err = err
+			ui.picker.isOpen = true
+			return
+		}
+		ui.scheduleDoc = doc
+		return
+	}
+	data := []byte(scheduleText)
+	if enc, ok := schedule.EncodingOf(ui.schedulePath); ok {
+		if ui.scheduleDoc == nil {
+			name := strings.TrimSuffix(filepath.Base(ui.schedulePath), filepath.Ext(ui.schedulePath))
+			ui.scheduleDoc = &schedule.Document{Name: name}
+		}
+		err := ui.scheduleDoc.SetText(scheduleText, ui.rateUnit)
+		if err == nil {
+			data, err = ui.scheduleDoc.Encode(enc)
+		}
+		if err != nil {
+			fmt.Println("Error saving schedule:", err)
+			return
+		}
+	}
+	err := config.WriteFile(ui.schedulePath, data, 0644)
 	if err != nil {
 		// Handle error (could show in UI but for now we'll just ignore)
 		fmt.Println("Error saving schedule:", err)
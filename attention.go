@@ -0,0 +1,46 @@
+package main
+
+import (
+	"gio_flicker/attention"
+	"log"
+	"time"
+)
+
+// Start the attention task of a session starting at now, when the
+// fixation mark changes color
+func startAttentionTask(ui *UI, now time.Time) {
+	endAttentionTask(ui, now)
+	f := ui.fixation.spec
+	if f == nil || f.Change.A == 0 {
+		return
+	}
+	task := attention.New(f.ChangeEvery, f.ChangeFor, uint64(now.UnixNano()), now)
+	ui.fixation.task = task
+	log.Printf("Attention task started: %s changes every %s on average, respond within %s (seed %d)",
+		f.ChangeFor, f.ChangeEvery, task.Window, task.Seed())
+}
+
+// Record a response key pressed at now
+func respondAttention(ui *UI, now time.Time) {
+	task := ui.fixation.task
+	if task == nil {
+		return
+	}
+	if target, hit := task.Respond(now); hit {
+		log.Printf("Attention response: reaction time %s", target.Response.Round(time.Millisecond))
+	} else {
+		log.Printf("Attention response: false alarm")
+	}
+}
+
+// End the attention task of the session at now and log its results
+func endAttentionTask(ui *UI, now time.Time) {
+	task := ui.fixation.task
+	if task == nil {
+		return
+	}
+	ui.fixation.task = nil
+	results := task.Results(now)
+	ui.fixation.results = results.String()
+	log.Printf("Attention task results: %s", results)
+}
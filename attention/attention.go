@@ -0,0 +1,158 @@
+// Package attention runs the attention task of a session. The fixation
+// mark changes color at random times, the targets, and participants press
+// a key whenever they see a change. Reaction times are measured from the
+// first frame that shows a target.
+package attention
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// DefaultWindow is the longest reaction time that counts as a response.
+const DefaultWindow = 1500 * time.Millisecond
+
+// Target is a color change of the fixation mark.
+type Target struct {
+	Onset    time.Time     // first frame showing the target
+	Response time.Duration // reaction time, zero while unanswered
+}
+
+// Task plans the targets of a session and scores the responses. Targets
+// follow each other at random intervals, uniform within half the mean
+// interval either way, so they can't be anticipated.
+type Task struct {
+	Every  time.Duration // mean time between targets
+	For    time.Duration // display time of a target
+	Window time.Duration // time to respond after the onset
+
+	seed        uint64
+	rand        *rand.Rand
+	next        time.Time // scheduled start of the current or next target
+	shown       bool      // the current target has been drawn
+	targets     []Target
+	falseAlarms int
+}
+
+// New starts a task at start with targets on average every interval,
+// shown for duration each. The same seed gives the same intervals.
+func New(every, duration time.Duration, seed uint64, start time.Time) *Task {
+	t := &Task{
+		Every:  every,
+		For:    duration,
+		Window: min(DefaultWindow, every/2),
+		seed:   seed,
+		rand:   rand.New(rand.NewPCG(seed, seed)),
+	}
+	t.next = start.Add(t.interval())
+	return t
+}
+
+// Seed returns the seed of the random intervals.
+func (t *Task) Seed() uint64 {
+	return t.seed
+}
+
+func (t *Task) interval() time.Duration {
+	return t.Every/2 + time.Duration(t.rand.Int64N(int64(t.Every)))
+}
+
+// Show is called for every frame drawn with the fixation mark at now. It
+// reports whether the frame shows a target and when that changes next.
+// Targets that end before a frame shows them are never counted.
+func (t *Task) Show(now time.Time) (target bool, next time.Time) {
+	for !now.Before(t.next.Add(t.For)) {
+		t.next = t.next.Add(t.interval())
+		t.shown = false
+	}
+	if now.Before(t.next) {
+		return false, t.next
+	}
+	if !t.shown {
+		t.shown = true
+		t.targets = append(t.targets, Target{Onset: now})
+	}
+	return true, t.next.Add(t.For)
+}
+
+// Respond records a key press at now. A press within the window of an
+// unanswered target is a hit and returns the target with its reaction
+// time, any other press is a false alarm.
+func (t *Task) Respond(now time.Time) (Target, bool) {
+	if n := len(t.targets); n > 0 {
+		target := &t.targets[n-1]
+		rt := now.Sub(target.Onset)
+		if target.Response == 0 && rt > 0 && rt <= t.Window {
+			target.Response = rt
+			return *target, true
+		}
+	}
+	t.falseAlarms++
+	return Target{}, false
+}
+
+// Results scores the targets shown until end. Targets whose window is
+// still open at end are left out unless they were answered.
+func (t *Task) Results(end time.Time) Results {
+	r := Results{FalseAlarms: t.falseAlarms}
+	for _, target := range t.targets {
+		switch {
+		case target.Response > 0:
+			r.Targets++
+			r.Hits++
+			r.ReactionTimes = append(r.ReactionTimes, target.Response)
+		case end.Sub(target.Onset) > t.Window:
+			r.Targets++
+			r.Misses++
+		}
+	}
+	return r
+}
+
+// Results are the scores of a session.
+type Results struct {
+	Targets       int
+	Hits          int
+	Misses        int
+	FalseAlarms   int
+	ReactionTimes []time.Duration // of the hits in order
+}
+
+// MeanRT returns the mean reaction time of the hits, zero without hits.
+func (r Results) MeanRT() time.Duration {
+	if len(r.ReactionTimes) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, rt := range r.ReactionTimes {
+		sum += rt
+	}
+	return sum / time.Duration(len(r.ReactionTimes))
+}
+
+// MedianRT returns the median reaction time of the hits, zero without hits.
+func (r Results) MedianRT() time.Duration {
+	n := len(r.ReactionTimes)
+	if n == 0 {
+		return 0
+	}
+	rts := slices.Clone(r.ReactionTimes)
+	slices.Sort(rts)
+	if n%2 == 0 {
+		return (rts[n/2-1] + rts[n/2]) / 2
+	}
+	return rts[n/2]
+}
+
+// String summarizes the results, e.g. "12 targets, 11 hits, 1 misses, 0
+// false alarms, reaction time mean 412ms, median 398ms".
+func (r Results) String() string {
+	s := fmt.Sprintf("%d targets, %d hits, %d misses, %d false alarms", r.Targets, r.Hits, r.Misses, r.FalseAlarms)
+	if r.Hits > 0 {
+		s += fmt.Sprintf(", reaction time mean %s, median %s",
+			r.MeanRT().Round(time.Millisecond), r.MedianRT().Round(time.Millisecond))
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+package attention
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+const frame = 10 * time.Millisecond
+
+// session draws frames until end and presses the key after every target
+// with the delays of press for that target, in order. A negative delay
+// presses before the target, between it and the one before.
+func session(t *testing.T, task *Task, end time.Time, press func(target int) []time.Duration) Results {
+	t.Helper()
+	var presses []time.Time
+	targets := 0
+	for now := start; now.Before(end); now = now.Add(frame) {
+		task.Show(now)
+		if n := len(task.targets); n > targets {
+			for _, d := range press(targets) {
+				presses = append(presses, task.targets[n-1].Onset.Add(d))
+			}
+			targets = n
+		}
+		slices.SortFunc(presses, func(a, b time.Time) int { return a.Compare(b) })
+		for len(presses) > 0 && !presses[0].After(now) {
+			task.Respond(presses[0])
+			presses = presses[1:]
+		}
+	}
+	return task.Results(end)
+}
+
+func TestIntervals(t *testing.T) {
+	task := New(4*time.Second, 500*time.Millisecond, 7, start)
+	session(t, task, start.Add(10*time.Minute), func(int) []time.Duration { return nil })
+	if len(task.targets) < 100 {
+		t.Fatalf("%d targets in 10 minutes, want about 150", len(task.targets))
+	}
+	last := start
+	for i, target := range task.targets {
+		gap := target.Onset.Sub(last)
+		if gap < 2*time.Second || gap > 6*time.Second+frame {
+			t.Errorf("target %d after %v, want 2s to 6s", i, gap)
+		}
+		last = target.Onset
+	}
+
+	// The same seed gives the same targets
+	again := New(4*time.Second, 500*time.Millisecond, 7, start)
+	session(t, again, start.Add(10*time.Minute), func(int) []time.Duration { return nil })
+	if !slices.Equal(task.targets, again.targets) {
+		t.Error("the same seed gave different targets")
+	}
+}
+
+func TestScoring(t *testing.T) {
+	always := func(d ...time.Duration) func(int) []time.Duration {
+		return func(int) []time.Duration { return d }
+	}
+	tests := []struct {
+		name  string
+		press func(target int) []time.Duration
+		want  func(targets int) Results // expected results for the number of targets
+	}{
+		{"no presses", always(), func(n int) Results {
+			return Results{Targets: n, Misses: n}
+		}},
+		{"every target at 400ms", always(400 * time.Millisecond), func(n int) Results {
+			return Results{Targets: n, Hits: n, ReactionTimes: repeat(400*time.Millisecond, n)}
+		}},
+		{"at the end of the window", always(DefaultWindow), func(n int) Results {
+			return Results{Targets: n, Hits: n, ReactionTimes: repeat(DefaultWindow, n)}
+		}},
+		{"too late", always(DefaultWindow + frame), func(n int) Results {
+			return Results{Targets: n, Misses: n, FalseAlarms: n}
+		}},
+		{"twice", always(300*time.Millisecond, 600*time.Millisecond), func(n int) Results {
+			return Results{Targets: n, Hits: n, FalseAlarms: n, ReactionTimes: repeat(300*time.Millisecond, n)}
+		}},
+		{"before the target", always(-500 * time.Millisecond), func(n int) Results {
+			return Results{Targets: n, Misses: n, FalseAlarms: n}
+		}},
+		{"every other target", func(i int) []time.Duration {
+			if i%2 == 0 {
+				return []time.Duration{time.Duration(200+100*i) * time.Millisecond}
+			}
+			return nil
+		}, func(n int) Results {
+			r := Results{Targets: n, Hits: (n + 1) / 2, Misses: n / 2}
+			for i := 0; i < n; i += 2 {
+				r.ReactionTimes = append(r.ReactionTimes, time.Duration(200+100*i)*time.Millisecond)
+			}
+			return r
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := New(4*time.Second, 500*time.Millisecond, 1, start)
+			// End well after a target so every window is closed
+			end := start.Add(time.Minute)
+			got := session(t, task, end, tt.press)
+			for _, target := range task.targets {
+				if end.Sub(target.Onset) <= DefaultWindow {
+					t.Fatal("a target is still open at the end, pick another seed or end")
+				}
+			}
+			want := tt.want(len(task.targets))
+			if got.Targets != want.Targets || got.Hits != want.Hits || got.Misses != want.Misses ||
+				got.FalseAlarms != want.FalseAlarms || !slices.Equal(got.ReactionTimes, want.ReactionTimes) {
+				t.Errorf("got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	return slices.Repeat([]time.Duration{d}, n)
+}
+
+func TestOpenWindow(t *testing.T) {
+	task := New(4*time.Second, 500*time.Millisecond, 1, start)
+	session(t, task, start.Add(time.Minute), func(int) []time.Duration { return nil })
+	last := task.targets[len(task.targets)-1].Onset
+	// A target whose window is still open is left out until answered
+	if r := task.Results(last.Add(time.Second)); r.Targets != len(task.targets)-1 {
+		t.Errorf("%d targets with the last window open, want %d", r.Targets, len(task.targets)-1)
+	}
+	if _, hit := task.Respond(last.Add(time.Second)); !hit {
+		t.Fatal("press within the window is not a hit")
+	}
+	if r := task.Results(last.Add(time.Second)); r.Targets != len(task.targets) || r.Hits != 1 {
+		t.Errorf("results %+v after the hit", r)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	if w := New(2*time.Second, 200*time.Millisecond, 1, start).Window; w != time.Second {
+		t.Errorf("window %v for targets every 2s, want 1s", w)
+	}
+	if w := New(8*time.Second, 200*time.Millisecond, 1, start).Window; w != DefaultWindow {
+		t.Errorf("window %v for targets every 8s, want %v", w, DefaultWindow)
+	}
+}
+
+func TestResults(t *testing.T) {
+	tests := []struct {
+		rts          []time.Duration
+		mean, median time.Duration
+		text         string
+	}{
+		{nil, 0, 0, "0 targets, 0 hits, 0 misses, 0 false alarms"},
+		{[]time.Duration{400 * time.Millisecond}, 400 * time.Millisecond, 400 * time.Millisecond,
+			"1 targets, 1 hits, 0 misses, 0 false alarms, reaction time mean 400ms, median 400ms"},
+		{[]time.Duration{500 * time.Millisecond, 300 * time.Millisecond, 400 * time.Millisecond, 1200 * time.Millisecond},
+			600 * time.Millisecond, 450 * time.Millisecond,
+			"4 targets, 4 hits, 0 misses, 0 false alarms, reaction time mean 600ms, median 450ms"},
+	}
+	for _, tt := range tests {
+		r := Results{Targets: len(tt.rts), Hits: len(tt.rts), ReactionTimes: tt.rts}
+		if r.MeanRT() != tt.mean || r.MedianRT() != tt.median {
+			t.Errorf("%v: mean %v median %v, want %v and %v", tt.rts, r.MeanRT(), r.MedianRT(), tt.mean, tt.median)
+		}
+		if r.String() != tt.text {
+			t.Errorf("%v: %q, want %q", tt.rts, r.String(), tt.text)
+		}
+	}
+}
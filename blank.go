@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"gio_flicker/stimulus"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"image/color"
+	"log"
+	"strings"
+)
+
+// Appearance of the stimulus area during blank steps and while stopped
+type blankScreen struct {
+	mean      bool         // fill with the mean color of the stimulus
+	color     *color.NRGBA // fixed fill color, nil for none
+	image     *IMG         // static image drawn over the fill
+	imagePath string
+	err       error
+}
+
+// Read the blank color editor: empty leaves the area unpainted, "mean"
+// matches the mean luminance of the stimulus, or a color such as #808080
+func parseBlankColor(ui *UI) {
+	text := strings.TrimSpace(ui.blankEditor.Text())
+	b := &ui.blank
+	b.mean, b.color, b.err = false, nil, nil
+	switch {
+	case text == "":
+	case strings.EqualFold(text, "mean"):
+		b.mean = true
+	default:
+		c, err := stimulus.ParseColor(text)
+		if err != nil {
+			b.err = errors.New(`blank color: expected "mean" or a color such as #808080`)
+			return
+		}
+		b.color = &c
+	}
+}
+
+// Use the image file at path on blanks, an empty path removes the image
+func setBlankImage(ui *UI, path string, img *IMG) {
+	ui.blank.image, ui.blank.imagePath = img, path
+	if img != nil {
+		log.Printf("Blank image: %s", imageName(path))
+	}
+}
+
+// Load the blank image remembered in the settings
+func loadBlankImage(ui *UI, path string) {
+	if path == "" {
+		return
+	}
+	img, err := loadImageFile(path)
+	if err != nil {
+		log.Printf("Error loading the blank image: %v", err)
+		ui.blank.err = err
+		return
+	}
+	setBlankImage(ui, path, &img)
+}
+
+// Mean color of the session stimulus in linear light
+func stimulusMean(ui *UI) color.NRGBA {
+	if ui.pattern != nil && ui.patternErr == nil {
+		gray := ui.pattern.MeanGray()
+		return color.NRGBA{R: gray, G: gray, B: gray, A: 255}
+	}
+	return framesMean(ui.images)
+}
+
+// Mean color of frames shown in turn
+func framesMean(frames []IMG) color.NRGBA {
+	means := make([]color.NRGBA, len(frames))
+	for i, img := range frames {
+		means[i] = img.mean
+	}
+	return stimulus.MixColors(means...)
+}
+
+// Fill color of the blank screen, nil to leave the area unpainted
+func blankFill(ui *UI) *color.NRGBA {
+	if ui.blank.mean {
+		c := stimulusMean(ui)
+		return &c
+	}
+	return ui.blank.color
+}
+
+// Draw the blank screen: the fill color and the static image
+func drawBlank(gtx layout.Context, ui *UI) layout.Dimensions {
+	size := gtx.Constraints.Max
+	if fill := blankFill(ui); fill != nil {
+		paint.FillShape(gtx.Ops, *fill, clip.Rect{Max: size}.Op())
+	}
+	if img := ui.blank.image; img != nil {
+		drawImage(gtx, img.imgOp, img.imgSize)
+	}
+	return layout.Dimensions{Size: size}
+}
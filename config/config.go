@@ -0,0 +1,137 @@
+// Package config stores the settings and schedules of the app in the
+// user's config directory, so they survive restarts no matter which
+// directory the app is started from.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gio_flicker/stimulus"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EnvVar names the environment variable that overrides the config
+// directory.
+const EnvVar = "BRAIN_FLICKER_CONFIG"
+
+// Dir returns the config directory: override if it is not empty, else the
+// directory in EnvVar, else brain-flicker inside os.UserConfigDir.
+func Dir(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	if dir := os.Getenv(EnvVar); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no config directory, set %s: %w", EnvVar, err)
+	}
+	return filepath.Join(dir, "brain-flicker"), nil
+}
+
+// Settings are the preferences kept between runs. Zero values mean the
+// app defaults.
+type Settings struct {
+	Rate        float64          `json:"rate,omitempty"`
+	RateUnit    string           `json:"rate_unit,omitempty"`  // "flips/s" or "Hz"
+	DutyCycle   float64          `json:"duty_cycle,omitempty"` // percentage showing the first image
+	Waveform    string           `json:"waveform,omitempty"`
+	UseSchedule bool             `json:"use_schedule,omitempty"`
+	Schedule    string           `json:"schedule,omitempty"` // library schedule in the editor, empty for the schedule file
+	FrameLocked bool             `json:"frame_locked,omitempty"`
+	RefreshHz   float64          `json:"refresh_hz,omitempty"`
+	FadeS       float64          `json:"fade_s,omitempty"`    // fade in and out at the session and step boundaries, 0 for none
+	FadeRate    bool             `json:"fade_rate,omitempty"` // fade the rate along with the contrast
+	Window      Size             `json:"window"`
+	Images      []string         `json:"images,omitempty"`   // stimulus image files in order, empty for the built-in checkerboards
+	FrameMS     []float64        `json:"frame_ms,omitempty"` // display time of each frame, empty to follow the rate
+	Pattern     string           `json:"pattern,omitempty"`  // generated stimulus spec, empty for the images
+	Viewing     stimulus.Viewing `json:"viewing"`
+	Blank       Blank            `json:"blank"`
+	Fixation    Fixation         `json:"fixation"`
+	Consent     Consent          `json:"consent"`
+	Limits      Limits           `json:"limits"`
+	Exposure    Exposure         `json:"exposure"`
+}
+
+// Blank is the appearance of blank steps and the stopped screen.
+type Blank struct {
+	Color string `json:"color,omitempty"` // "mean" or a color such as "#808080", empty for no fill
+	Image string `json:"image,omitempty"` // static image file
+}
+
+// Fixation is the mark in the middle of the stimulus area.
+type Fixation struct {
+	Spec       string `json:"spec,omitempty"`        // such as "cross 0.5deg", empty for none
+	BlanksOnly bool   `json:"blanks_only,omitempty"` // hide the mark while the stimulus is shown
+}
+
+// Consent records the acknowledgement of the health warning.
+type Consent struct {
+	Acknowledged *time.Time `json:"acknowledged,omitempty"` // last acknowledgement, nil for never
+	RepeatDays   int        `json:"repeat_days,omitempty"`  // days until it is asked again, 0 on every start
+}
+
+// Due reports whether the health warning has to be acknowledged at now.
+func (c Consent) Due(now time.Time) bool {
+	if c.Acknowledged == nil || c.RepeatDays <= 0 {
+		return true
+	}
+	return !now.Before(c.Acknowledged.AddDate(0, 0, c.RepeatDays))
+}
+
+// Limits cap the flicker exposure. Zero uses the default, a negative value
+// turns a limit off.
+type Limits struct {
+	MaxContinuousMin float64 `json:"max_continuous_min,omitempty"` // flicker without a break, 60 by default
+	MaxDailyMin      float64 `json:"max_daily_min,omitempty"`      // flicker per day, 180 by default
+	BreakMin         float64 `json:"break_min,omitempty"`          // enforced rest, 15 by default
+	WarningS         float64 `json:"warning_s,omitempty"`          // countdown before the stop, 30 by default
+}
+
+// Exposure is the flicker time of the day and since the last break.
+type Exposure struct {
+	Day         string     `json:"day,omitempty"` // YYYY-MM-DD
+	DailyS      float64    `json:"daily_s,omitempty"`
+	ContinuousS float64    `json:"continuous_s,omitempty"`
+	LastFlicker *time.Time `json:"last_flicker,omitempty"`
+	BreakUntil  *time.Time `json:"break_until,omitempty"`
+}
+
+// Size is a window size in device independent pixels.
+type Size struct {
+	Width  float32 `json:"width,omitempty"`
+	Height float32 `json:"height,omitempty"`
+}
+
+const settingsFile = "settings.json"
+
+// Load reads the settings from dir. A missing file gives the defaults.
+func Load(dir string) (Settings, error) {
+	var s Settings
+	data, err := os.ReadFile(filepath.Join(dir, settingsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Settings{}, fmt.Errorf("%s: %w", filepath.Join(dir, settingsFile), err)
+	}
+	return s, nil
+}
+
+// Save writes the settings to dir.
+func Save(dir string, s Settings) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFile(filepath.Join(dir, settingsFile), append(data, '\n'), 0644)
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// names returns the names of the files in dir.
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	name := filepath.Join(dir, "settings.json")
+	for _, data := range []string{"first", "second, longer"} {
+		if err := WriteFile(name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(name)
+		if err != nil || string(got) != data {
+			t.Errorf("read %q, %v, want %q", got, err, data)
+		}
+	}
+	if got := names(t, dir); !slices.Equal(got, []string{"settings.json"}) {
+		t.Errorf("files %q, want no temporary files", got)
+	}
+	if runtime.GOOS != "windows" {
+		if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("mode %v, %v, want 0600", fi.Mode(), err)
+		}
+	}
+}
+
+func TestWriteFileError(t *testing.T) {
+	dir := t.TempDir()
+	// A directory in the way makes the rename fail
+	target := filepath.Join(dir, "schedule.txt")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(target, []byte("data"), 0644); err == nil {
+		t.Error("wrote over a directory")
+	}
+	if got := names(t, dir); !slices.Equal(got, []string{"schedule.txt"}) {
+		t.Errorf("files %q, want the temporary file removed", got)
+	}
+	if fi, err := os.Stat(target); err != nil || !fi.IsDir() {
+		t.Errorf("the directory is gone: %v", err)
+	}
+
+	// A file in place of the directory
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(filepath.Join(file, "settings.json"), []byte("data"), 0644); err == nil {
+		t.Error("wrote below a file")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "keep" {
+		t.Errorf("file changed to %q", data)
+	}
+}
+
+func TestDir(t *testing.T) {
+	env := t.TempDir()
+	t.Setenv(EnvVar, env)
+	if dir, err := Dir("override"); err != nil || dir != "override" {
+		t.Errorf("Dir with an override = %q, %v", dir, err)
+	}
+	if dir, err := Dir(""); err != nil || dir != env {
+		t.Errorf("Dir with %s set = %q, %v, want %q", EnvVar, dir, err, env)
+	}
+
+	t.Setenv(EnvVar, "")
+	if runtime.GOOS == "linux" {
+		t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	}
+	user, err := os.UserConfigDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	if dir, err := Dir(""); err != nil || dir != filepath.Join(user, "brain-flicker") {
+		t.Errorf("Dir = %q, %v, want brain-flicker in %q", dir, err, user)
+	}
+
+	if runtime.GOOS == "linux" {
+		t.Setenv("XDG_CONFIG_HOME", "")
+		t.Setenv("HOME", "")
+		if _, err := Dir(""); err == nil || !strings.Contains(err.Error(), EnvVar) {
+			t.Errorf("error %v without a config directory, want one naming %s", err, EnvVar)
+		}
+	}
+}
+
+// chdir changes the working directory for the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestMigrateSchedule(t *testing.T) {
+	wd := t.TempDir()
+	chdir(t, wd)
+	dir := filepath.Join(t.TempDir(), "brain-flicker")
+
+	// Nothing to copy
+	if old, err := MigrateSchedule(dir); err != nil || old != "" {
+		t.Errorf("migrated %q, %v from an empty directory", old, err)
+	}
+
+	if err := os.WriteFile("schedule.txt", []byte("10s @ 12Hz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := MigrateSchedule(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(wd, "schedule.txt"); old != want {
+		t.Errorf("migrated %q, want %q", old, want)
+	}
+	if data, err := os.ReadFile(ScheduleFile(dir)); err != nil || string(data) != "10s @ 12Hz" {
+		t.Errorf("schedule %q, %v", data, err)
+	}
+	if _, err := os.Stat("schedule.txt"); err != nil {
+		t.Errorf("the old file is gone: %v", err)
+	}
+
+	// The schedule in dir is never replaced
+	if err := os.WriteFile("schedule.txt", []byte("5s blank"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if old, err := MigrateSchedule(dir); err != nil || old != "" {
+		t.Errorf("migrated %q, %v a second time", old, err)
+	}
+	if data, _ := os.ReadFile(ScheduleFile(dir)); string(data) != "10s @ 12Hz" {
+		t.Errorf("schedule replaced by %q", data)
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "brain-flicker")
+	s, err := Load(dir)
+	if err != nil || !reflect.DeepEqual(s, Settings{}) {
+		t.Errorf("Load without a file = %+v, %v, want the defaults", s, err)
+	}
+
+	acknowledged := time.Date(2024, 3, 14, 9, 30, 0, 0, time.UTC)
+	s = Settings{
+		Rate:      40,
+		RateUnit:  "Hz",
+		DutyCycle: 25,
+		Waveform:  "sine",
+		Schedule:  "40 Hz gamma, 1 hour",
+		Window:    Size{Width: 800, Height: 600},
+		Images:    []string{"/a.png", "/b.png"},
+		FrameMS:   []float64{100, 50.5},
+		Blank:     Blank{Color: "mean"},
+		Fixation:  Fixation{Spec: "cross 0.5deg", BlanksOnly: true},
+		Consent:   Consent{Acknowledged: &acknowledged, RepeatDays: 7},
+		Limits:    Limits{MaxContinuousMin: 20, BreakMin: -1},
+		Exposure:  Exposure{Day: "2024-03-14", DailyS: 90.5},
+	}
+	if err := Save(dir, s); err != nil {
+		t.Fatal(err)
+	}
+	back, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, s) {
+		t.Errorf("round trip:\n%+v\n%+v", back, s)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "settings.json"))
+	if !strings.HasSuffix(string(data), "}\n") || strings.Contains(string(data), `"fade_s"`) {
+		t.Errorf("settings file:\n%s", data)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "settings.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "settings.json") {
+		t.Errorf("error %v for a broken file, want one naming it", err)
+	}
+}
+
+func TestConsentDue(t *testing.T) {
+	acknowledged := time.Date(2024, 3, 14, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		consent Consent
+		now     time.Time
+		want    bool
+	}{
+		{Consent{}, acknowledged, true},
+		{Consent{RepeatDays: 7}, acknowledged, true},
+		{Consent{Acknowledged: &acknowledged}, acknowledged.Add(time.Minute), true},
+		{Consent{Acknowledged: &acknowledged, RepeatDays: -1}, acknowledged.Add(time.Minute), true},
+		{Consent{Acknowledged: &acknowledged, RepeatDays: 7}, acknowledged.AddDate(0, 0, 6), false},
+		{Consent{Acknowledged: &acknowledged, RepeatDays: 7}, acknowledged.AddDate(0, 0, 7), true},
+	}
+	for _, tt := range tests {
+		if got := tt.consent.Due(tt.now); got != tt.want {
+			t.Errorf("%+v due at %v: %v, want %v", tt.consent, tt.now, got, tt.want)
+		}
+	}
+}
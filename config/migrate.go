@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ScheduleFile returns the schedule file inside dir.
+func ScheduleFile(dir string) string {
+	return filepath.Join(dir, "schedule.txt")
+}
+
+// MigrateSchedule copies a schedule.txt left by older versions, which kept
+// it in the working directory, into dir. The working directory is checked
+// first, then the directory of the executable. Nothing happens once dir has
+// a schedule. It returns the file that was copied, or "" if none was. The
+// old file is left in place.
+func MigrateSchedule(dir string) (string, error) {
+	target := ScheduleFile(dir)
+	if _, err := os.Stat(target); !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	var candidates []string
+	if wd, err := os.Getwd(); err == nil {
+		candidates = append(candidates, filepath.Join(wd, "schedule.txt"))
+	}
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), "schedule.txt"))
+	}
+	for _, old := range candidates {
+		data, err := os.ReadFile(old)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return old, WriteFile(target, data, 0644)
+	}
+	return "", nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"gioui.org/font"
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"image"
+	"image/color"
+	"log"
+	"time"
+)
+
+// Health warning shown before the app can be used, as in the README
+var healthWarning = []string{
+	"DO NOT use this application if you have epilepsy, a history of seizures, or other photosensitive conditions",
+	"DO NOT use this application if you are tired, sleep-deprived, or under the influence of alcohol",
+	"Stop using immediately if you experience any discomfort, dizziness, nausea, disorientation, or visual disturbances",
+	"Keep the room well lit when using this application",
+	"Take regular breaks and limit usage time",
+	"Maintain a safe distance from the screen",
+}
+
+// ConsentDialog shows the health warning and has to be acknowledged before
+// the flicker can start
+type ConsentDialog struct {
+	isOpen         bool
+	agree          widget.Bool
+	continueButton widget.Clickable
+	quitButton     widget.Clickable
+}
+
+// Open the dialog when the last acknowledgement is too old
+func checkConsent(ui *UI, now time.Time) {
+	ui.consent.isOpen = ui.settings.Consent.Due(now)
+	if ui.consent.isOpen {
+		log.Printf("Health warning shown, waiting for the acknowledgement")
+	}
+}
+
+// Record the acknowledgement of the health warning at now
+func acknowledgeConsent(ui *UI, now time.Time) {
+	ui.consent.isOpen = false
+	ui.settings.Consent.Acknowledged = &now
+	log.Printf("Health warning acknowledged at %s", now.Format(time.RFC3339))
+	if days := ui.settings.Consent.RepeatDays; days > 0 {
+		log.Printf("The health warning is shown again after %s", now.AddDate(0, 0, days).Format(time.DateOnly))
+	}
+	// Save now, so a crash or a killed app doesn't ask again early
+	saveSettings(ui)
+}
+
+func (d *ConsentDialog) Layout(gtx layout.Context, th *material.Theme) layout.Dimensions {
+	if !d.isOpen {
+		return layout.Dimensions{}
+	}
+
+	// Keep clicks from reaching the controls below
+	area := clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops)
+	event.Op(gtx.Ops, d)
+	for {
+		if _, ok := gtx.Event(pointer.Filter{Target: d, Kinds: pointer.Press | pointer.Release}); !ok {
+			break
+		}
+	}
+	area.Pop()
+
+	gtx.Constraints.Min = gtx.Constraints.Max
+
+	return layout.Stack{}.Layout(gtx,
+		layout.Expanded(d.layoutBackground),
+		layout.Stacked(func(gtx layout.Context) layout.Dimensions {
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min = image.Point{}
+				gtx.Constraints.Max.X = min(gtx.Constraints.Max.X, gtx.Dp(560))
+				return layout.Stack{}.Layout(gtx,
+					layout.Expanded(d.layoutPopupBackground),
+					layout.Stacked(d.layoutContent(th)),
+				)
+			})
+		}),
+	)
+}
+
+func (d *ConsentDialog) layoutBackground(gtx layout.Context) layout.Dimensions {
+	paint.Fill(gtx.Ops, color.NRGBA{A: 230})
+	return layout.Dimensions{Size: gtx.Constraints.Min}
+}
+
+func (d *ConsentDialog) layoutPopupBackground(gtx layout.Context) layout.Dimensions {
+	r := gtx.Dp(8)
+	paint.FillShape(gtx.Ops,
+		color.NRGBA{R: 255, G: 255, B: 255, A: 255},
+		clip.RRect{Rect: image.Rectangle{Max: gtx.Constraints.Min}, NE: r, NW: r, SE: r, SW: r}.Op(gtx.Ops))
+	return layout.Dimensions{Size: gtx.Constraints.Min}
+}
+
+func (d *ConsentDialog) layoutContent(th *material.Theme) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(unit.Dp(16)).Layout(gtx,
+			func(gtx layout.Context) layout.Dimensions {
+				children := []layout.FlexChild{
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						title := material.H6(th, "Health warning")
+						title.Alignment = text.Middle
+						return title.Layout(gtx)
+					}),
+					space(16),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(th, "This application shows flashing images that may trigger seizures in people with photosensitive epilepsy.")
+						label.Font.Weight = font.Bold
+						return label.Layout(gtx)
+					}),
+					space(8),
+				}
+				for _, line := range healthWarning {
+					children = append(children, layout.Rigid(material.Body2(th, "• "+line).Layout), space(4))
+				}
+				children = append(children,
+					space(8),
+					layout.Rigid(material.Body2(th, "This application is for experimental purposes only. It is not a medical device and is not intended to diagnose, treat, cure, or prevent any disease or condition.").Layout),
+					space(16),
+					layout.Rigid(material.CheckBox(th, &d.agree, "I have read the warning and take part at my own risk").Layout),
+					space(16),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Flex{Spacing: layout.SpaceBetween}.Layout(gtx,
+							layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+								gtx.Constraints.Min.X = gtx.Constraints.Max.X
+								return material.Button(th, &d.quitButton, "Quit").Layout(gtx)
+							}),
+							layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+							layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+								gtx.Constraints.Min.X = gtx.Constraints.Max.X
+								if !d.agree.Value {
+									gtx = gtx.Disabled()
+								}
+								return material.Button(th, &d.continueButton, "Continue").Layout(gtx)
+							}),
+						)
+					}),
+				)
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+			},
+		)
+	}
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"time"
+)
+
+// Clock is the source of time used by the engine. Production code uses
+// SystemClock; tests can substitute a FakeClock to drive the engine
+// deterministically.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of time.Timer the engine needs.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// SystemClock returns a Clock backed by the time package.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (s systemTimer) C() <-chan time.Time {
+	return s.t.C
+}
+
+func (s systemTimer) Stop() bool {
+	return s.t.Stop()
+}
@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// EndAction selects what happens when a schedule reaches its last item.
+type EndAction int
+
+const (
+	LoopForever EndAction = iota // start over indefinitely
+	StopAtEnd                    // end the session
+	LoopTimes                    // play the schedule EndPolicy.Times times, then end
+	HoldBlank                    // end the session but keep a blank screen until stopped
+)
+
+// EndPolicy is the schedule level "on end" setting.
+type EndPolicy struct {
+	Action EndAction
+	Times  int // number of plays for LoopTimes
+}
+
+func (p EndPolicy) String() string {
+	switch p.Action {
+	case StopAtEnd:
+		return "stop"
+	case LoopTimes:
+		return fmt.Sprintf("loop %d", p.Times)
+	case HoldBlank:
+		return "blank"
+	}
+	return "loop"
+}
+
+// Schedule is a list of items together with what happens after the last one.
+type Schedule struct {
+	Items []ScheduleItem
+	OnEnd EndPolicy
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEndPolicy(t *testing.T) {
+	tests := []struct {
+		policy   EndPolicy
+		steps    []int         // steps entered, in order
+		end      time.Duration // when the session finished, 0 if it doesn't
+		running  bool          // still running after the end
+		finished bool
+	}{
+		{EndPolicy{Action: StopAtEnd}, []int{0, 1}, 2 * time.Second, false, true},
+		{EndPolicy{Action: LoopTimes, Times: 2}, []int{0, 1, 0, 1}, 4 * time.Second, false, true},
+		{EndPolicy{Action: LoopTimes, Times: 1}, []int{0, 1}, 2 * time.Second, false, true},
+		{EndPolicy{Action: HoldBlank}, []int{0, 1}, 2 * time.Second, true, true},
+		{EndPolicy{Action: LoopForever}, []int{0, 1, 0, 1, 0, 1}, 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			clock := NewFakeClock(epoch)
+			e, ch := events(clock)
+			e.SetSchedule(Schedule{
+				Items: []ScheduleItem{
+					{Duration: time.Second, FlickeringRate: Flips(4)},
+					{Duration: time.Second, Blank: true},
+				},
+				OnEnd: tt.policy,
+			})
+			e.Start()
+			defer e.Stop()
+
+			var steps []int
+			var last Event
+			for last = range ch {
+				if last.Finished || last.Time.Sub(epoch) >= 6*time.Second {
+					break
+				}
+				if len(steps) == 0 || steps[len(steps)-1] != last.Step {
+					steps = append(steps, last.Step)
+				}
+				advance(clock, e)
+			}
+			if !slices.Equal(steps, tt.steps) {
+				t.Errorf("steps %v, want %v", steps, tt.steps)
+			}
+			if last.Finished != tt.finished {
+				t.Errorf("finished %v, want %v", last.Finished, tt.finished)
+			}
+			if tt.finished {
+				if got := last.Time.Sub(epoch); got != tt.end {
+					t.Errorf("finished after %v, want %v", got, tt.end)
+				}
+				if !last.Blank || !e.State().Finished {
+					t.Errorf("state %+v after the end, want a finished blank", e.State())
+				}
+			}
+			if e.Running() != tt.running {
+				t.Errorf("running %v, want %v", e.Running(), tt.running)
+			}
+		})
+	}
+}
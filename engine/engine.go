@@ -0,0 +1,412 @@
+// Package engine drives the flicker timing independently of the GUI. The
+// front end subscribes to phase changes through a callback and only has to
+// redraw; everything that decides when a phase changes lives here so it can
+// be exercised with a FakeClock.
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ScheduleItem is one step of a flicker schedule.
+type ScheduleItem struct {
+	Duration       time.Duration
+	FlickeringRate Rate    // rate at the start of the item
+	EndRate        Rate    // rate at the end of a sweep
+	Sweep          Sweep   // how the rate moves from FlickeringRate to EndRate
+	DutyCycle      float64 // share of each cycle showing the first image, 0 uses the session value
+	Waveform       Waveform
+	Blank          bool // blank screen instead of flickering
+
+	// Stimulus is what the item shows, in the notation of the schedule
+	// language, e.g. `pattern "checkerboard 1deg"`. The engine only passes
+	// it on; empty shows the session stimulus.
+	Stimulus string
+	Frames   int // number of frames of Stimulus, 0 for the session sequence
+}
+
+// Event describes the stimulus state after a change.
+type Event struct {
+	Phase int          // index of the frame to show, the dominant image of two
+	Mix   float32      // weight of the second of two images, 0 shows only the first
+	Step  int          // index of the current schedule item, -1 without a schedule
+	Blank bool         // true while a blank schedule item is active
+	Item  ScheduleItem // item in effect with session defaults filled in
+	Fade  float32      // share of the contrast taken away, 0 outside fades and 1 for only the mean color
+	Rate  Rate         // instantaneous rate, changing during sweeps and fades
+	Time  time.Time
+
+	// Finished is set once the schedule has completed according to its
+	// end policy, or a fade out has ended the session
+	Finished bool
+	FadedOut bool // Finished by FadeOut
+}
+
+// Engine steps through the frames of a sequence, by default alternating
+// between two phases, at a fixed rate or following a schedule and reports
+// every change to its callback.
+//
+// By default phase changes are driven by timers. In frame-locked mode the
+// engine has no goroutine; the front end calls Frame once per displayed
+// frame and every phase lasts a whole number of refresh frames. Waveforms
+// other than Square also need Frame calls to sample the crossfade, and so do
+// the fades of a Ramp.
+type Engine struct {
+	clock    Clock
+	onChange func(Event)
+
+	mu        sync.Mutex
+	rate      Rate
+	duty      float64
+	waveform  Waveform
+	schedule  Schedule
+	sequence  Sequence
+	ramp      Ramp
+	refreshHz float64 // 0 unless frame-locked
+	running   bool
+	run       *run
+	frames    *FrameCounter
+	state     Event
+	stop      chan struct{}
+	done      chan struct{}
+	wake      chan struct{} // the loop has to wait for a new time
+}
+
+// New creates a stopped engine. onChange is called from the engine
+// goroutine and must not call Stop or Restart.
+func New(clock Clock, onChange func(Event)) *Engine {
+	if onChange == nil {
+		onChange = func(Event) {}
+	}
+	return &Engine{
+		clock:    clock,
+		onChange: onChange,
+		rate:     Flips(1),
+		duty:     0.5,
+		waveform: Square,
+		state:    Event{Step: -1},
+	}
+}
+
+// FlipPeriod converts flips per second to the time between two flips with
+// nanosecond resolution.
+func FlipPeriod(flipsPerSecond float64) (time.Duration, error) {
+	if !(flipsPerSecond > 0) {
+		return 0, fmt.Errorf("flips per second must be positive, got: %g", flipsPerSecond)
+	}
+	if flipsPerSecond > 100 {
+		return 0, fmt.Errorf("flips per second must be <= 100, got: %g", flipsPerSecond)
+	}
+	return time.Duration(math.Round(float64(time.Second) / flipsPerSecond)), nil
+}
+
+// SetRate sets the rate used when no schedule is active. It takes effect
+// on the next Start.
+func (e *Engine) SetRate(rate Rate) error {
+	if _, err := FlipPeriod(rate.FlipsPerSecond()); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.rate = rate
+	e.mu.Unlock()
+	return nil
+}
+
+// Rate returns the rate used when no schedule is active.
+func (e *Engine) Rate() Rate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rate
+}
+
+// SetDutyCycle sets the share of each cycle that shows the first image for
+// the single rate and for schedule items without their own duty cycle. It
+// takes effect on the next Start.
+func (e *Engine) SetDutyCycle(duty float64) error {
+	if err := ValidateDutyCycle(duty); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.duty = duty
+	e.mu.Unlock()
+	return nil
+}
+
+// DutyCycle returns the session duty cycle.
+func (e *Engine) DutyCycle() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.duty
+}
+
+// SetWaveform sets the modulation waveform for the single rate and for
+// schedule items without their own waveform. It takes effect on the next
+// Start.
+func (e *Engine) SetWaveform(w Waveform) {
+	if w == DefaultWaveform {
+		w = Square
+	}
+	e.mu.Lock()
+	e.waveform = w
+	e.mu.Unlock()
+}
+
+// Waveform returns the session waveform.
+func (e *Engine) Waveform() Waveform {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.waveform
+}
+
+// SetSchedule sets the schedule to follow. A schedule without items makes
+// the engine flicker at the single rate. It takes effect on the next Start.
+func (e *Engine) SetSchedule(s Schedule) {
+	var items []ScheduleItem
+	for _, item := range s.Items {
+		// Items without a duration would never let the schedule advance
+		if item.Duration > 0 {
+			// Out of range duty cycles fall back to the session value
+			if ValidateDutyCycle(item.DutyCycle) != nil {
+				item.DutyCycle = 0
+			}
+			items = append(items, item)
+		}
+	}
+	e.mu.Lock()
+	e.schedule = Schedule{Items: items, OnEnd: s.OnEnd}
+	e.mu.Unlock()
+}
+
+// SetSequence sets the frames to step through. It takes effect on the next
+// Start.
+func (e *Engine) SetSequence(s Sequence) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	s.Durations = append([]time.Duration(nil), s.Durations...)
+	e.mu.Lock()
+	e.sequence = s
+	e.mu.Unlock()
+	return nil
+}
+
+// Sequence returns the frames stepped through.
+func (e *Engine) Sequence() Sequence {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.sequence
+}
+
+// SetRamp sets the fades at the start and end of the session and its
+// steps. It takes effect on the next Start.
+func (e *Engine) SetRamp(r Ramp) {
+	r.Duration = max(0, r.Duration)
+	e.mu.Lock()
+	e.ramp = r
+	e.mu.Unlock()
+}
+
+// Ramp returns the fades of the session.
+func (e *Engine) Ramp() Ramp {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ramp
+}
+
+// SetFrameLocked switches between timer driven flicker (refreshHz 0) and
+// frame-locked flicker on a display refreshing at refreshHz. It takes
+// effect on the next Start.
+func (e *Engine) SetFrameLocked(refreshHz float64) {
+	if refreshHz < 0 {
+		refreshHz = 0
+	}
+	e.mu.Lock()
+	e.refreshHz = refreshHz
+	e.mu.Unlock()
+}
+
+// FrameLocked reports whether the engine expects Frame calls.
+func (e *Engine) FrameLocked() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.refreshHz > 0
+}
+
+// NeedsFrames reports whether the front end has to call Frame for every
+// displayed frame of the running session, which includes the fades.
+func (e *Engine) NeedsFrames() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.running && (e.frames != nil || e.run.continuous() || e.run.fade(e.clock.Now()) > 0)
+}
+
+// Running reports whether a session is active.
+func (e *Engine) Running() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.running
+}
+
+// State returns the most recent stimulus state.
+func (e *Engine) State() Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state
+}
+
+// Start begins flickering. It does nothing if the engine is already running.
+func (e *Engine) Start() {
+	e.mu.Lock()
+	if e.running {
+		e.mu.Unlock()
+		return
+	}
+	e.running = true
+	var quantum time.Duration
+	if e.refreshHz > 0 {
+		quantum = time.Duration(float64(time.Second) / e.refreshHz)
+		e.frames = NewFrameCounter(quantum)
+	}
+	e.run = newRun(e.rate, e.duty, e.waveform, e.schedule, e.sequence, e.ramp, e.clock.Now(), quantum)
+	e.state = e.run.event()
+	ev := e.state
+	if quantum == 0 {
+		e.stop, e.done, e.wake = make(chan struct{}), make(chan struct{}), make(chan struct{}, 1)
+		go e.loop(e.run, e.stop, e.done, e.wake)
+	}
+	e.mu.Unlock()
+
+	e.onChange(ev)
+}
+
+// Stop halts the engine and waits for its goroutine to exit.
+func (e *Engine) Stop() {
+	e.mu.Lock()
+	stop, done := e.stop, e.done
+	e.stop, e.done, e.wake = nil, nil, nil
+	e.running = false
+	e.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// FadeOut ends the running session after a fade out and reports whether
+// it started one. It does nothing without a ramp, during a blank step, in a
+// finished session or when a fade out is already under way; Stop ends the
+// session at once.
+func (e *Engine) FadeOut() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r := e.run
+	if !e.running || e.ramp.Duration <= 0 || r.finished || r.item.Blank || !r.end.IsZero() {
+		return false
+	}
+	at := e.clock.Now()
+	if e.frames != nil {
+		// Frame-locked runs count time in frames
+		at = r.now
+	}
+	r.fadeOut(at)
+	if e.wake != nil {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// Restart applies changed settings to a running engine.
+func (e *Engine) Restart() {
+	if !e.Running() {
+		return
+	}
+	e.Stop()
+	e.Start()
+}
+
+// Frame returns the state to draw for the frame presented at now. A
+// frame-locked session is advanced to that frame; dropped frames are
+// detected from the gap since the previous call so the stimulus stays
+// locked to the display. A timer driven session is only sampled, which
+// gives continuous waveforms their crossfade.
+//
+// When a frame-locked schedule finishes, the completion event is passed to
+// the callback from Frame.
+func (e *Engine) Frame(now time.Time) Event {
+	e.mu.Lock()
+	if !e.running {
+		defer e.mu.Unlock()
+		return e.state
+	}
+	finished := e.state.Finished
+	if e.frames == nil {
+		e.state = e.run.sample(now)
+	} else {
+		n := e.frames.Observe(now)
+		t := e.run.start.Add(time.Duration(n) * e.frames.Period())
+		e.run.advanceTo(t)
+		e.state = e.run.sample(t)
+		if e.run.finished && !e.run.hold {
+			e.running = false
+		}
+	}
+	e.state.Time = now
+	ev := e.state
+	e.mu.Unlock()
+
+	if ev.Finished && !finished {
+		e.onChange(ev)
+	}
+	return ev
+}
+
+func (e *Engine) loop(r *run, stop, done, wake chan struct{}) {
+	defer close(done)
+
+	for {
+		e.mu.Lock()
+		finished, next := r.finished, r.next
+		e.mu.Unlock()
+		if finished {
+			// Holding the final blank screen until stopped
+			<-stop
+			return
+		}
+		timer := e.clock.NewTimer(next.Sub(e.clock.Now()))
+		select {
+		case <-timer.C():
+			e.mu.Lock()
+			if !r.next.Equal(next) {
+				// A fade out moved the next change
+				e.mu.Unlock()
+				continue
+			}
+			r.advance()
+			e.state = r.event()
+			ev := e.state
+			end := r.finished && !r.hold
+			if end && e.stop == stop {
+				// The schedule is over, go back to idle
+				e.running = false
+				e.stop, e.done, e.wake = nil, nil, nil
+			}
+			e.mu.Unlock()
+			e.onChange(ev)
+			if end {
+				return
+			}
+		case <-wake:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
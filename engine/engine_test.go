@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// events returns a stopped engine and a channel receiving every change it
+// reports.
+func events(clock Clock) (*Engine, chan Event) {
+	ch := make(chan Event, 16)
+	return New(clock, func(ev Event) { ch <- ev }), ch
+}
+
+// advance moves the clock to the next change the engine waits for.
+func advance(clock *FakeClock, e *Engine) {
+	clock.BlockUntil(1)
+	e.mu.Lock()
+	next := e.run.next
+	e.mu.Unlock()
+	clock.Advance(next.Sub(clock.Now()))
+}
+
+// advanceTo lets the engine apply every change up to and including t.
+func advanceTo(clock *FakeClock, e *Engine, t time.Time) {
+	for {
+		clock.BlockUntil(1)
+		e.mu.Lock()
+		next := e.run.next
+		e.mu.Unlock()
+		if next.After(t) {
+			return
+		}
+		clock.Advance(next.Sub(clock.Now()))
+	}
+}
+
+func TestFlipLoop(t *testing.T) {
+	tests := []struct {
+		rate   Rate
+		period time.Duration
+	}{
+		{Flips(1), time.Second},
+		{Flips(4), 250 * time.Millisecond},
+		{Flips(50), 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rate.String(), func(t *testing.T) {
+			clock := NewFakeClock(epoch)
+			e, ch := events(clock)
+			if err := e.SetRate(tt.rate); err != nil {
+				t.Fatal(err)
+			}
+			e.Start()
+			if ev := <-ch; ev.Phase != 0 || ev.Step != -1 || !ev.Time.Equal(epoch) {
+				t.Fatalf("start %+v, want phase 0 at the start", ev)
+			}
+			for k := 1; k <= 10; k++ {
+				advance(clock, e)
+				ev := <-ch
+				if want := epoch.Add(time.Duration(k) * tt.period); !ev.Time.Equal(want) || ev.Phase != k%2 {
+					t.Fatalf("flip %d at %v to phase %d, want %v to phase %d",
+						k, ev.Time.Sub(epoch), ev.Phase, want.Sub(epoch), k%2)
+				}
+			}
+			e.Stop()
+			if e.Running() {
+				t.Error("still running after Stop")
+			}
+			select {
+			case ev := <-ch:
+				t.Errorf("change %+v after Stop", ev)
+			default:
+			}
+		})
+	}
+}
+
+func TestScheduleLoop(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	e.SetSchedule(Schedule{Items: []ScheduleItem{
+		{Duration: time.Second, FlickeringRate: Flips(2)},
+		{Duration: 500 * time.Millisecond, Blank: true},
+		// Items without a duration are left out
+		{FlickeringRate: Flips(10)},
+	}})
+	e.Start()
+	defer e.Stop()
+
+	want := []struct {
+		at    time.Duration
+		step  int
+		phase int
+		blank bool
+	}{
+		{0, 0, 0, false},
+		{500 * time.Millisecond, 0, 1, false},
+		{time.Second, 1, 0, true},
+		// The schedule starts over after the last item
+		{1500 * time.Millisecond, 0, 0, false},
+		{2 * time.Second, 0, 1, false},
+		{2500 * time.Millisecond, 1, 0, true},
+	}
+	for i, w := range want {
+		if i > 0 {
+			advance(clock, e)
+		}
+		ev := <-ch
+		if got := ev.Time.Sub(epoch); got != w.at || ev.Step != w.step || ev.Phase != w.phase || ev.Blank != w.blank {
+			t.Errorf("change %d: %v step %d phase %d blank %v, want %v step %d phase %d blank %v",
+				i, got, ev.Step, ev.Phase, ev.Blank, w.at, w.step, w.phase, w.blank)
+		}
+	}
+}
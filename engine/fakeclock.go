@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a manually advanced Clock. Timers created from it only fire
+// when Advance moves the clock past their deadline, which makes engine
+// behaviour reproducible in tests.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	pending []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewFakeClock returns a FakeClock whose current time is start.
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.pending = append(c.pending, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, firing every timer whose deadline
+// falls inside the interval in deadline order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.pending, func(i, j int) bool {
+		return c.pending[i].deadline.Before(c.pending[j].deadline)
+	})
+	kept := c.pending[:0]
+	for _, t := range c.pending {
+		if t.deadline.After(c.now) {
+			kept = append(kept, t)
+			continue
+		}
+		t.c <- t.deadline
+	}
+	c.pending = kept
+}
+
+// BlockUntil waits until at least n timers are pending on the clock. Tests
+// use it to make sure the engine goroutine is waiting before calling
+// Advance.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) < n {
+		c.cond.Wait()
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pending {
+		if p == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
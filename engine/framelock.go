@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// FrameCounter turns frame timestamps into a count of display refreshes.
+// A gap of roughly two refresh periods between timestamps counts as two
+// frames, so a dropped frame does not shift the phase of the stimulus.
+type FrameCounter struct {
+	period time.Duration
+	last   time.Time
+	frames int64
+}
+
+// NewFrameCounter returns a counter for a display refreshing every period.
+func NewFrameCounter(period time.Duration) *FrameCounter {
+	return &FrameCounter{period: period}
+}
+
+// Period returns the refresh period the counter assumes.
+func (f *FrameCounter) Period() time.Duration {
+	return f.period
+}
+
+// Observe records a frame presented at t and returns the number of refresh
+// frames since the first observed frame.
+func (f *FrameCounter) Observe(t time.Time) int64 {
+	if f.last.IsZero() {
+		f.last = t
+		return 0
+	}
+	n := int64(math.Round(float64(t.Sub(f.last)) / float64(f.period)))
+	if n < 1 {
+		n = 1
+	}
+	f.last = t
+	f.frames += n
+	return f.frames
+}
+
+// FramesPerPhase returns how many refresh frames the first and second
+// phase of a cycle last at rate and duty cycle on a refreshHz display.
+// Every phase lasts at least one frame.
+func FramesPerPhase(rate Rate, duty, refreshHz float64) (first, second int) {
+	perCycle := refreshHz / rate.Hz()
+	first = max(1, int(math.Round(perCycle*duty)))
+	second = max(1, int(math.Round(perCycle*(1-duty))))
+	return first, second
+}
+
+// RefreshMeter estimates the display refresh rate from frame timestamps.
+type RefreshMeter struct {
+	last   time.Time
+	deltas []time.Duration
+}
+
+const refreshSamples = 120
+
+// Observe records a frame presented at t.
+func (m *RefreshMeter) Observe(t time.Time) {
+	if !m.last.IsZero() {
+		d := t.Sub(m.last)
+		// Ignore pauses between animations
+		if d > 0 && d < 100*time.Millisecond {
+			m.deltas = append(m.deltas, d)
+			if len(m.deltas) > refreshSamples {
+				m.deltas = m.deltas[1:]
+			}
+		}
+	}
+	m.last = t
+}
+
+// Hz returns the estimated refresh rate, or 0 before enough frames have
+// been observed. The median interval is used so dropped frames do not
+// skew the estimate.
+func (m *RefreshMeter) Hz() float64 {
+	if len(m.deltas) < 10 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), m.deltas...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return float64(time.Second) / float64(sorted[len(sorted)/2])
+}
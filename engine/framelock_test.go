@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+// phaseRuns returns the lengths of the runs of equal phases shown on
+// frames displayed at refreshHz.
+func phaseRuns(t *testing.T, rate Rate, refreshHz float64, frames int) []int {
+	t.Helper()
+	clock := NewFakeClock(epoch)
+	e, _ := events(clock)
+	if err := e.SetRate(rate); err != nil {
+		t.Fatal(err)
+	}
+	e.SetFrameLocked(refreshHz)
+	e.Start()
+	defer e.Stop()
+
+	period := time.Duration(float64(time.Second) / refreshHz)
+	var runs []int
+	last := -1
+	for i := range frames {
+		ev := e.Frame(epoch.Add(time.Duration(i) * period))
+		if ev.Phase == last {
+			runs[len(runs)-1]++
+		} else {
+			runs = append(runs, 1)
+			last = ev.Phase
+		}
+	}
+	return runs
+}
+
+func TestFrameLockedPhases(t *testing.T) {
+	tests := []struct {
+		rate Rate
+		want int // frames per phase
+	}{
+		{Hz(15), 2},
+		{Flips(15), 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rate.String(), func(t *testing.T) {
+			runs := phaseRuns(t, tt.rate, 60, 48)
+			// The last run may be cut off by the end of the frames
+			for i, n := range runs[:len(runs)-1] {
+				if n != tt.want {
+					t.Fatalf("run %d lasts %d frames, want %d: %v", i, n, tt.want, runs)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameCounter(t *testing.T) {
+	const period = 16 * time.Millisecond
+	f := NewFrameCounter(period)
+	tests := []struct {
+		at   time.Duration // presentation time of the frame
+		want int64
+	}{
+		{0, 0},
+		{16 * time.Millisecond, 1},
+		{33 * time.Millisecond, 2}, // late
+		{47 * time.Millisecond, 3}, // early
+		{80 * time.Millisecond, 5}, // a dropped frame
+		{81 * time.Millisecond, 6}, // never less than one frame
+		{150 * time.Millisecond, 10},
+	}
+	for _, tt := range tests {
+		if got := f.Observe(epoch.Add(tt.at)); got != tt.want {
+			t.Errorf("frame at %v counted %d, want %d", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestFramesPerPhase(t *testing.T) {
+	tests := []struct {
+		rate          Rate
+		duty, refresh float64
+		first, second int
+	}{
+		{Hz(15), 0.5, 60, 2, 2},
+		{Hz(10), 0.5, 60, 3, 3},
+		{Hz(10), 0.25, 120, 3, 9},
+		{Hz(40), 0.5, 60, 1, 1}, // at least a frame each
+		{Flips(20), 0.5, 144, 7, 7},
+	}
+	for _, tt := range tests {
+		first, second := FramesPerPhase(tt.rate, tt.duty, tt.refresh)
+		if first != tt.first || second != tt.second {
+			t.Errorf("%v at %g%% on %g Hz: %d+%d frames, want %d+%d",
+				tt.rate, tt.duty*100, tt.refresh, first, second, tt.first, tt.second)
+		}
+	}
+}
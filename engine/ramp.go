@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"math"
+	"time"
+)
+
+// Ramp fades the flicker in at the start of the session and of every
+// schedule step, and out at the end of every step and when the session is
+// faded out, so the stimulus never jumps to or from full contrast.
+type Ramp struct {
+	Duration time.Duration // length of a fade, 0 for none
+
+	// Frequency also raises the rate from zero during a fade in and lowers
+	// it to zero during a fade out
+	Frequency bool
+}
+
+// envelope is the gain of an item of length l seconds that fades over d
+// seconds: rising from 0 to 1, holding and falling back to 0 at the end.
+// Items shorter than two fades reach a lower peak.
+type envelope struct {
+	d, l float64 // l is +Inf for items without an end
+}
+
+// peak returns the highest gain and the time it is reached.
+func (e envelope) peak() (h, a float64) {
+	h = min(1, e.l/(2*e.d))
+	return h, h * e.d
+}
+
+// at returns the gain x seconds into the item.
+func (e envelope) at(x float64) float64 {
+	return max(0, min(1, x/e.d, (e.l-x)/e.d))
+}
+
+// integral returns the gain integrated from the start of the item to x,
+// the time an item running at the gain has progressed.
+func (e envelope) integral(x float64) float64 {
+	h, a := e.peak()
+	b := e.l - a
+	x = max(0, min(x, e.l))
+	switch {
+	case x <= a:
+		return x * x / (2 * e.d)
+	case x <= b:
+		return a*a/(2*e.d) + h*(x-a)
+	}
+	y := x - b
+	return a*a/(2*e.d) + h*(b-a) + h*y - y*y/(2*e.d)
+}
+
+// inverse returns the time x at which the integral reaches v, +Inf if it
+// never does.
+func (e envelope) inverse(v float64) float64 {
+	h, a := e.peak()
+	b := e.l - a
+	rise := a * a / (2 * e.d)
+	switch {
+	case v <= 0:
+		return 0
+	case v <= rise:
+		return math.Sqrt(2 * e.d * v)
+	case v <= rise+h*(b-a):
+		return a + (v-rise)/h
+	}
+	// Solve h*y - y^2/(2d) = v for the falling part
+	v -= rise + h*(b-a)
+	disc := h*h - 2*v/e.d
+	if disc < 0 {
+		return math.Inf(1)
+	}
+	return b + e.d*(h-math.Sqrt(disc))
+}
+
+// envelope returns the fade of the current item.
+func (r *run) envelope() envelope {
+	end := r.itemEnd()
+	l := math.Inf(1)
+	if !end.IsZero() {
+		l = end.Sub(r.flipBase).Seconds()
+	}
+	return envelope{d: r.ramp.Duration.Seconds(), l: l}
+}
+
+// itemEnd returns the end of the current item, the earlier of the end of
+// the schedule step and of a fade out, or zero for none.
+func (r *run) itemEnd() time.Time {
+	end := r.end
+	if r.step >= 0 && (end.IsZero() || r.stepEnd.Before(end)) {
+		end = r.stepEnd
+	}
+	return end
+}
+
+// fade returns the share of the contrast taken away at t, 0 outside the
+// fades.
+func (r *run) fade(t time.Time) float32 {
+	if r.ramp.Duration <= 0 || r.item.Blank {
+		return 0
+	}
+	return float32(1 - r.envelope().at(t.Sub(r.flipBase).Seconds()))
+}
+
+// rateFades reports whether the rate of the current item follows the fades.
+func (r *run) rateFades() bool {
+	return r.ramp.Frequency && r.ramp.Duration > 0 && !r.item.Blank
+}
+
+// progress returns how far an item running at the faded rate has come d
+// into the item, in time at the full rate.
+func (r *run) progress(d time.Duration) time.Duration {
+	if !r.rateFades() {
+		return d
+	}
+	return time.Duration(math.Round(r.envelope().integral(d.Seconds()) * float64(time.Second)))
+}
+
+// progressTime is the inverse of progress: the time into the item at which
+// it has come as far as d at the full rate.
+func (r *run) progressTime(d time.Duration) time.Duration {
+	if !r.rateFades() || d >= never {
+		return d
+	}
+	x := r.envelope().inverse(d.Seconds())
+	if math.IsInf(x, 0) || x*float64(time.Second) >= float64(never) {
+		return never
+	}
+	return time.Duration(math.Round(x * float64(time.Second)))
+}
+
+// rateAt returns the instantaneous rate at t including the fades.
+func (r *run) rateAt(t time.Time) Rate {
+	d := t.Sub(r.flipBase)
+	rate := r.item.RateAt(r.progress(d))
+	if r.rateFades() {
+		rate.Value *= r.envelope().at(d.Seconds())
+	}
+	return rate
+}
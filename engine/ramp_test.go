@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEnvelope(t *testing.T) {
+	tests := []struct {
+		name string
+		e    envelope
+		x    float64
+		gain float64
+		area float64 // integral up to x
+	}{
+		{"start", envelope{1, 4}, 0, 0, 0},
+		{"rising", envelope{1, 4}, 0.5, 0.5, 0.125},
+		{"top", envelope{1, 4}, 1, 1, 0.5},
+		{"holding", envelope{1, 4}, 2, 1, 1.5},
+		{"falling", envelope{1, 4}, 3.5, 0.5, 2.875},
+		{"end", envelope{1, 4}, 4, 0, 3},
+		{"past the end", envelope{1, 4}, 5, 0, 3},
+		{"short peak", envelope{1, 1}, 0.5, 0.5, 0.125},
+		{"short end", envelope{1, 1}, 1, 0, 0.25},
+		{"no end", envelope{2, math.Inf(1)}, 100, 1, 99},
+	}
+	for _, tt := range tests {
+		if got := tt.e.at(tt.x); math.Abs(got-tt.gain) > 1e-12 {
+			t.Errorf("%s: gain %g at %g, want %g", tt.name, got, tt.x, tt.gain)
+		}
+		if got := tt.e.integral(tt.x); math.Abs(got-tt.area) > 1e-12 {
+			t.Errorf("%s: integral %g at %g, want %g", tt.name, got, tt.x, tt.area)
+		}
+	}
+}
+
+func TestEnvelopeInverse(t *testing.T) {
+	for _, e := range []envelope{{1, 4}, {1, 1}, {0.5, 1.5}, {2, math.Inf(1)}, {0.1, 3600}} {
+		end := min(e.l, 10)
+		for x := 0.0; x < end; x += end / 97 {
+			if got := e.inverse(e.integral(x)); math.Abs(got-x) > 1e-9 {
+				t.Errorf("%+v: inverse(integral(%g)) = %g", e, x, got)
+			}
+		}
+		if !math.IsInf(e.l, 1) {
+			if got := e.inverse(e.integral(e.l) + 0.01); !math.IsInf(got, 1) {
+				t.Errorf("%+v: past the whole area at %g, want never", e, got)
+			}
+		}
+	}
+}
+
+// fadeAt is the share of the contrast taken away expected at a time.
+type fadeAt struct {
+	at   time.Duration
+	fade float32
+}
+
+func TestFadeIn(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		schedule Schedule
+		want     []fadeAt
+	}{
+		{"session", Schedule{}, []fadeAt{{0, 1}, {250 * ms, 0.75}, {500 * ms, 0.5}, {time.Second, 0}, {5 * time.Second, 0}}},
+		{"step", Schedule{Items: []ScheduleItem{{Duration: 3 * time.Second, FlickeringRate: Hz(1)}}},
+			[]fadeAt{{0, 1}, {500 * ms, 0.5}, {1500 * ms, 0}, {2 * time.Second, 0}, {2500 * ms, 0.5}, {2750 * ms, 0.75}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(epoch)
+			e, _ := events(clock)
+			if err := e.SetRate(Hz(1)); err != nil {
+				t.Fatal(err)
+			}
+			e.SetRamp(Ramp{Duration: time.Second})
+			e.SetSchedule(tt.schedule)
+			e.Start()
+			defer e.Stop()
+			if !e.NeedsFrames() {
+				t.Error("a fade does not ask for frames")
+			}
+			for _, w := range tt.want {
+				advanceTo(clock, e, epoch.Add(w.at))
+				ev := e.Frame(epoch.Add(w.at))
+				if math.Abs(float64(ev.Fade-w.fade)) > 1e-6 {
+					t.Errorf("fade %g at %v, want %g", ev.Fade, w.at, w.fade)
+				}
+				if ev.Rate != Hz(1) {
+					t.Errorf("rate %v at %v, want 1 Hz without a frequency fade", ev.Rate, w.at)
+				}
+			}
+		})
+	}
+}
+
+func TestFadeOut(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	if e.FadeOut() {
+		t.Error("faded out a stopped engine")
+	}
+	if err := e.SetRate(Hz(1)); err != nil {
+		t.Fatal(err)
+	}
+	e.SetRamp(Ramp{Duration: time.Second})
+	e.Start()
+	<-ch
+
+	// Four flips, every 500ms
+	for range 4 {
+		advance(clock, e)
+		<-ch
+	}
+	if !e.FadeOut() {
+		t.Fatal("no fade out")
+	}
+	if e.FadeOut() {
+		t.Error("a second fade out started during the first")
+	}
+	if ev := e.Frame(epoch.Add(2250 * time.Millisecond)); math.Abs(float64(ev.Fade-0.25)) > 1e-6 {
+		t.Errorf("fade %g at 2.25s, want 0.25", ev.Fade)
+	}
+
+	// The flip at 2.5s keeps its place, the session ends a fade after 2s
+	advance(clock, e)
+	if ev := <-ch; ev.Finished || ev.Time.Sub(epoch) != 2500*time.Millisecond {
+		t.Errorf("%+v, want the flip at 2.5s", ev)
+	}
+	if ev := e.Frame(epoch.Add(2750 * time.Millisecond)); math.Abs(float64(ev.Fade-0.75)) > 1e-6 {
+		t.Errorf("fade %g at 2.75s, want 0.75", ev.Fade)
+	}
+	advance(clock, e)
+	ev := <-ch
+	if !ev.Finished || !ev.FadedOut || ev.Time.Sub(epoch) != 3*time.Second {
+		t.Errorf("%+v, want the end of the fade at 3s", ev)
+	}
+	if e.Running() {
+		t.Error("still running after the fade out")
+	}
+	if e.FadeOut() {
+		t.Error("faded out a finished session")
+	}
+}
+
+func TestFadeOutStop(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	e.SetRamp(Ramp{Duration: 10 * time.Second})
+	e.Start()
+	<-ch
+	if !e.FadeOut() {
+		t.Fatal("no fade out")
+	}
+	// A second stop during the fade ends the session at once
+	if e.FadeOut() {
+		t.Error("a second fade out started during the first")
+	}
+	e.Stop()
+	if e.Running() {
+		t.Error("still running after Stop during a fade out")
+	}
+
+	// Nothing to fade without a ramp
+	e.SetRamp(Ramp{})
+	e.Start()
+	defer e.Stop()
+	<-ch
+	if e.FadeOut() {
+		t.Error("faded out without a ramp")
+	}
+}
+
+func TestFrequencyFade(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	if err := e.SetRate(Flips(4)); err != nil {
+		t.Fatal(err)
+	}
+	e.SetRamp(Ramp{Duration: time.Second, Frequency: true})
+	e.Start()
+	defer e.Stop()
+	if ev := <-ch; ev.Rate.Value != 0 || ev.Fade != 1 {
+		t.Errorf("start %+v, want no rate and no contrast", ev)
+	}
+	if ev := e.Frame(epoch.Add(500 * time.Millisecond)); math.Abs(ev.Rate.FlipsPerSecond()-2) > 1e-9 {
+		t.Errorf("%g flips/s half way through the fade, want 2", ev.Rate.FlipsPerSecond())
+	}
+
+	// The rate rises linearly, so the first flip comes when the area under
+	// it reaches a quarter second, sqrt(0.5)s in
+	want := []time.Duration{707106781, time.Second, 1250 * time.Millisecond, 1500 * time.Millisecond}
+	for k, w := range want {
+		advance(clock, e)
+		ev := <-ch
+		if got := ev.Time.Sub(epoch); got < w-1 || got > w+1 {
+			t.Errorf("flip %d at %v, want %v", k+1, got, w)
+		}
+	}
+	if ev := e.State(); ev.Rate != Flips(4) || ev.Fade != 0 {
+		t.Errorf("%+v after the fade, want 4 flips/s at full contrast", ev)
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RateUnit says what a flicker rate counts. A full on/off cycle consists
+// of two phase reversals, so 40 Hz means 80 flips per second.
+type RateUnit int
+
+const (
+	FlipsPerSecond RateUnit = iota // phase reversals per second
+	Hertz                          // full on/off cycles per second
+)
+
+func (u RateUnit) String() string {
+	if u == Hertz {
+		return "Hz"
+	}
+	return "flips/s"
+}
+
+// Rate is a flicker frequency together with its unit.
+type Rate struct {
+	Value float64
+	Unit  RateUnit
+}
+
+// Flips returns a rate of n phase reversals per second.
+func Flips(n float64) Rate {
+	return Rate{Value: n, Unit: FlipsPerSecond}
+}
+
+// Hz returns a rate of n full cycles per second.
+func Hz(n float64) Rate {
+	return Rate{Value: n, Unit: Hertz}
+}
+
+// FlipsPerSecond returns the number of phase reversals per second.
+func (r Rate) FlipsPerSecond() float64 {
+	if r.Unit == Hertz {
+		return 2 * r.Value
+	}
+	return r.Value
+}
+
+// Hz returns the number of full on/off cycles per second.
+func (r Rate) Hz() float64 {
+	if r.Unit == Hertz {
+		return r.Value
+	}
+	return r.Value / 2
+}
+
+// In converts the rate to unit.
+func (r Rate) In(unit RateUnit) Rate {
+	if unit == Hertz {
+		return Rate{Value: r.Hz(), Unit: Hertz}
+	}
+	return Rate{Value: r.FlipsPerSecond(), Unit: FlipsPerSecond}
+}
+
+// String formats the rate in its own unit followed by the other one, e.g.
+// "40 Hz (80 flips/s)", so logs are unambiguous.
+func (r Rate) String() string {
+	other := Rate{Value: r.FlipsPerSecond(), Unit: FlipsPerSecond}
+	if r.Unit == FlipsPerSecond {
+		other = Rate{Value: r.Hz(), Unit: Hertz}
+	}
+	return fmt.Sprintf("%s (%s)", r.Short(), other.Short())
+}
+
+// Short formats the rate in its own unit only, e.g. "40 Hz".
+func (r Rate) Short() string {
+	return strconv.FormatFloat(r.Value, 'f', -1, 64) + " " + r.Unit.String()
+}
+
+// PhaseDurations splits one cycle at rate into the time the first and the
+// second phase are shown. duty is the share of the cycle spent on the first
+// phase and must lie strictly between 0 and 1.
+func PhaseDurations(rate Rate, duty float64) (first, second time.Duration, err error) {
+	if _, err := FlipPeriod(rate.FlipsPerSecond()); err != nil {
+		return 0, 0, err
+	}
+	if err := ValidateDutyCycle(duty); err != nil {
+		return 0, 0, err
+	}
+	cycle := float64(time.Second) / rate.Hz()
+	first = time.Duration(math.Round(cycle * duty))
+	second = time.Duration(math.Round(cycle)) - first
+	return first, second, nil
+}
+
+// ValidateDutyCycle checks that duty leaves time for both phases.
+func ValidateDutyCycle(duty float64) error {
+	if !(duty > 0 && duty < 1) {
+		return fmt.Errorf("duty cycle must be between 0%% and 100%%, got: %g%%", duty*100)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFractionalRate(t *testing.T) {
+	tests := []struct {
+		rate  Rate
+		flips int
+	}{
+		{Flips(7.3), 1000},
+		{Flips(0.25), 10},
+		{Flips(99.9), 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rate.String(), func(t *testing.T) {
+			clock := NewFakeClock(epoch)
+			e, ch := events(clock)
+			if err := e.SetRate(tt.rate); err != nil {
+				t.Fatal(err)
+			}
+			e.Start()
+			defer e.Stop()
+			<-ch
+			for k := 1; k <= tt.flips; k++ {
+				advance(clock, e)
+				ev := <-ch
+				// Every flip is on the nominal time, rounded once, so
+				// rounding errors never add up
+				want := time.Duration(math.Round(float64(k) * float64(time.Second) / tt.rate.Value))
+				if got := ev.Time.Sub(epoch); got < want-1 || got > want+1 {
+					t.Fatalf("flip %d at %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFlipPeriodFractional(t *testing.T) {
+	p, err := FlipPeriod(7.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 136986301 * time.Nanosecond; p != want {
+		t.Errorf("period %v, want %v", p, want)
+	}
+}
+
+func TestRateUnits(t *testing.T) {
+	tests := []struct {
+		rate      Rate
+		flips, hz float64
+		text      string
+	}{
+		{Hz(40), 80, 40, "40 Hz (80 flips/s)"},
+		{Flips(15), 15, 7.5, "15 flips/s (7.5 Hz)"},
+		{Hz(0.5), 1, 0.5, "0.5 Hz (1 flips/s)"},
+	}
+	for _, tt := range tests {
+		if got := tt.rate.FlipsPerSecond(); got != tt.flips {
+			t.Errorf("%v: %g flips/s, want %g", tt.rate, got, tt.flips)
+		}
+		if got := tt.rate.Hz(); got != tt.hz {
+			t.Errorf("%v: %g Hz, want %g", tt.rate, got, tt.hz)
+		}
+		if got := tt.rate.String(); got != tt.text {
+			t.Errorf("%q, want %q", got, tt.text)
+		}
+		if got := tt.rate.In(Hertz); got != Hz(tt.hz) {
+			t.Errorf("%v in Hz is %v", tt.rate, got)
+		}
+		if got := tt.rate.In(FlipsPerSecond); got != Flips(tt.flips) {
+			t.Errorf("%v in flips/s is %v", tt.rate, got)
+		}
+	}
+}
+
+func TestFlipPeriod(t *testing.T) {
+	tests := []struct {
+		flips float64
+		want  time.Duration // 0 for an error
+	}{
+		{1, time.Second},
+		{80, 12500 * time.Microsecond},
+		{100, 10 * time.Millisecond},
+		{100.5, 0},
+		{120, 0},
+		{0, 0},
+		{-5, 0},
+		{math.NaN(), 0},
+	}
+	for _, tt := range tests {
+		got, err := FlipPeriod(tt.flips)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%g flips/s: period %v, want an error", tt.flips, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%g flips/s: period %v, %v, want %v", tt.flips, got, err, tt.want)
+		}
+	}
+
+	// The cap applies to flips, so 50 Hz is the fastest rate in Hz
+	e := New(NewFakeClock(epoch), nil)
+	if err := e.SetRate(Hz(50)); err != nil {
+		t.Errorf("50 Hz: %v", err)
+	}
+	if err := e.SetRate(Hz(60)); err == nil {
+		t.Error("60 Hz, 120 flips/s, was accepted")
+	}
+	if got := e.Rate(); got != Hz(50) {
+		t.Errorf("rate %v after a rejected rate, want the previous 50 Hz", got)
+	}
+}
+
+func TestHzFlips(t *testing.T) {
+	// 2 Hz is 4 flips/s, a flip every 250ms
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	if err := e.SetRate(Hz(2)); err != nil {
+		t.Fatal(err)
+	}
+	e.Start()
+	defer e.Stop()
+	<-ch
+	for k := 1; k <= 8; k++ {
+		advance(clock, e)
+		ev := <-ch
+		if got, want := ev.Time.Sub(epoch), time.Duration(k)*250*time.Millisecond; got != want {
+			t.Fatalf("flip %d at %v, want %v", k, got, want)
+		}
+		if ev.Rate != Hz(2) {
+			t.Errorf("rate %v, want 2 Hz", ev.Rate)
+		}
+	}
+}
+
+func TestDutyCycle(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	if err := e.SetRate(Hz(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetDutyCycle(0.25); err != nil {
+		t.Fatal(err)
+	}
+	e.Start()
+	defer e.Stop()
+	if ev := <-ch; ev.Phase != 0 {
+		t.Fatalf("starts with phase %d, want 0", ev.Phase)
+	}
+
+	want := []struct {
+		at    time.Duration
+		phase int
+	}{
+		{250 * time.Millisecond, 1},
+		{time.Second, 0},
+		{1250 * time.Millisecond, 1},
+		{2 * time.Second, 0},
+	}
+	now := time.Duration(0)
+	for _, w := range want {
+		clock.BlockUntil(1)
+		clock.Advance(w.at - now)
+		now = w.at
+		ev := <-ch
+		if got := ev.Time.Sub(epoch); got != w.at || ev.Phase != w.phase {
+			t.Errorf("flip at %v to phase %d, want %v to phase %d", got, ev.Phase, w.at, w.phase)
+		}
+	}
+}
+
+func TestPhaseDurations(t *testing.T) {
+	tests := []struct {
+		rate          Rate
+		duty          float64
+		first, second time.Duration // 0 for an error
+	}{
+		{Hz(1), 0.5, 500 * time.Millisecond, 500 * time.Millisecond},
+		{Hz(10), 0.25, 25 * time.Millisecond, 75 * time.Millisecond},
+		{Flips(3), 0.5, 333333333, 333333334}, // rounding goes to the second phase
+		{Hz(40), 0.1, 2500 * time.Microsecond, 22500 * time.Microsecond},
+		{Hz(1), 0, 0, 0},
+		{Hz(1), 1, 0, 0},
+		{Hz(60), 0.5, 0, 0},
+	}
+	for _, tt := range tests {
+		first, second, err := PhaseDurations(tt.rate, tt.duty)
+		if tt.first == 0 {
+			if err == nil {
+				t.Errorf("%v at %g%%: %v+%v, want an error", tt.rate, tt.duty*100, first, second)
+			}
+			continue
+		}
+		if err != nil || first != tt.first || second != tt.second {
+			t.Errorf("%v at %g%%: %v+%v, %v, want %v+%v", tt.rate, tt.duty*100, first, second, err, tt.first, tt.second)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package engine
+
+import (
+	"math"
+	"time"
+)
+
+// run holds the progress of a single started session.
+type run struct {
+	duty     float64
+	waveform Waveform
+	schedule []ScheduleItem
+	onEnd    EndPolicy
+	sequence Sequence
+	offsets  []time.Duration // start of each frame in a cycle of timed frames, then the cycle length
+	quantum  time.Duration   // refresh period when frame-locked, else 0
+	ramp     Ramp
+
+	start    time.Time
+	item     ScheduleItem // current item with session defaults filled in
+	phase    int
+	step     int
+	stepEnd  time.Time // end of the current schedule item
+	end      time.Time // end of a fade out, zero for none
+	flipBase time.Time // flips are counted from here
+	flips    int64
+	nextFlip time.Time
+	next     time.Time // earliest of nextFlip and stepEnd
+	now      time.Time
+	plays    int  // completed passes through the schedule
+	finished bool // the end policy ended the schedule
+	hold     bool // keep a blank screen after finishing
+	fadedOut bool // a fade out ended the session
+}
+
+// slowTick is the period used when a rate is invalid, matching the
+// behaviour of the original ticker.
+const slowTick = time.Second
+
+func newRun(rate Rate, duty float64, waveform Waveform, schedule Schedule, sequence Sequence, ramp Ramp, now time.Time, quantum time.Duration) *run {
+	r := &run{
+		duty:     duty,
+		waveform: waveform,
+		schedule: schedule.Items,
+		onEnd:    schedule.OnEnd,
+		sequence: sequence,
+		quantum:  quantum,
+		ramp:     ramp,
+		start:    now,
+		step:     -1,
+		now:      now,
+	}
+	if len(sequence.Durations) > 0 {
+		r.offsets = make([]time.Duration, len(sequence.Durations)+1)
+		for i, d := range sequence.Durations {
+			r.offsets[i+1] = r.offsets[i] + r.frames(d)
+		}
+	}
+	if len(r.schedule) > 0 {
+		r.enterStep(0, now)
+	} else {
+		r.item = r.resolve(ScheduleItem{FlickeringRate: rate})
+		r.flipBase = now
+		r.nextFlip = r.flipTime(1)
+	}
+	r.updateNext()
+	return r
+}
+
+// resolve fills in the session duty cycle and waveform where the item does
+// not set its own.
+func (r *run) resolve(item ScheduleItem) ScheduleItem {
+	if item.DutyCycle == 0 {
+		item.DutyCycle = r.duty
+	}
+	if item.Waveform == DefaultWaveform {
+		item.Waveform = r.waveform
+	}
+	if r.stepped(item) {
+		item.DutyCycle = 0.5
+		item.Waveform = Square
+	}
+	return item
+}
+
+// frameCount returns the number of frames of the current item.
+func (r *run) frameCount() int {
+	if r.item.Frames > 0 {
+		return r.item.Frames
+	}
+	return r.sequence.Len()
+}
+
+// stepped reports whether the frames of item are shown as discrete steps,
+// ignoring the duty cycle and waveform.
+func (r *run) stepped(item ScheduleItem) bool {
+	if item.Frames > 0 {
+		return item.Frames != 2
+	}
+	return r.sequence.stepped()
+}
+
+// frames rounds d to a whole number of refresh frames when frame-locked.
+func (r *run) frames(d time.Duration) time.Duration {
+	if r.quantum == 0 {
+		return d
+	}
+	n := (d + r.quantum/2) / r.quantum
+	if n < 1 {
+		n = 1
+	}
+	return n * r.quantum
+}
+
+// continuous reports whether any part of the run crossfades.
+func (r *run) continuous() bool {
+	items := r.schedule
+	if len(items) == 0 {
+		items = []ScheduleItem{{}}
+	}
+	for _, item := range items {
+		if !item.Blank && r.resolve(item).Waveform != Square {
+			return true
+		}
+	}
+	return false
+}
+
+// frameLocked reports whether phases are counted in whole frames. Sweeps
+// and rates that follow the fades change their period continuously and are
+// rounded to frames instead.
+func (r *run) frameLocked() bool {
+	return r.quantum > 0 && !r.item.sweeping() && !r.rateFades()
+}
+
+// theta returns the waveform position at t, which must not be past the
+// next scheduled change. The duty cycle maps the first phase onto the first
+// half of the waveform.
+func (r *run) theta(t time.Time) float64 {
+	if !r.item.valid() {
+		return 0.5 * float64(r.phase)
+	}
+	if r.frameLocked() {
+		refreshHz := float64(time.Second) / float64(r.quantum)
+		first, second := FramesPerPhase(r.item.FlickeringRate, r.item.DutyCycle, refreshHz)
+		f := int64(t.Sub(r.flipBase)/r.quantum) % int64(first+second)
+		if f < int64(first) {
+			return 0.5 * float64(f) / float64(first)
+		}
+		return 0.5 + 0.5*float64(f-int64(first))/float64(second)
+	}
+	cycles := r.item.cyclesAt(r.progress(t.Sub(r.flipBase)))
+	return warp(cycles-math.Floor(cycles), r.item.DutyCycle)
+}
+
+// flipTime returns the absolute time of the k-th flip since flipBase. Even
+// flips start a cycle with the first phase, odd flips switch to the second
+// phase after the duty cycle share of the cycle. Computing every flip from
+// the base instead of adding up rounded intervals keeps long sessions on
+// the nominal frequency and lets sweeps change the period continuously.
+func (r *run) flipTime(k int64) time.Time {
+	if r.offsets != nil && r.item.Frames == 0 {
+		// Timed frames follow their own durations instead of the rate
+		n := int64(len(r.offsets) - 1)
+		d := time.Duration(k/n)*r.offsets[n] + r.offsets[k%n]
+		if r.rateFades() {
+			return r.onGrid(r.flipBase.Add(r.progressTime(d)))
+		}
+		return r.flipBase.Add(d)
+	}
+	if !r.item.valid() {
+		return r.flipBase.Add(time.Duration(k) * r.frames(slowTick))
+	}
+	cycles, odd := k/2, k%2
+	if r.frameLocked() {
+		// Count frames directly so e.g. 15 Hz on 60 Hz is exactly 2+2 frames
+		refreshHz := float64(time.Second) / float64(r.quantum)
+		first, second := FramesPerPhase(r.item.FlickeringRate, r.item.DutyCycle, refreshHz)
+		return r.flipBase.Add(time.Duration(cycles*int64(first+second)+odd*int64(first)) * r.quantum)
+	}
+	return r.onGrid(r.flipBase.Add(r.progressTime(r.item.timeAt(float64(cycles) + float64(odd)*r.item.DutyCycle))))
+}
+
+// onGrid rounds a flip of a continuously changing period to the frame grid
+// when frame-locked, never flipping twice in a frame.
+func (r *run) onGrid(t time.Time) time.Time {
+	if r.quantum == 0 {
+		return t
+	}
+	t = r.flipBase.Add(r.frames(t.Sub(r.flipBase)))
+	if !t.After(r.now) {
+		t = r.now.Add(r.quantum)
+	}
+	return t
+}
+
+func (r *run) enterStep(i int, at time.Time) {
+	r.step = i
+	r.item = r.resolve(r.schedule[i])
+	r.stepEnd = at.Add(r.frames(r.item.Duration))
+	r.flipBase = at
+	r.flips = 0
+	r.phase = 0
+	r.nextFlip = r.flipTime(1)
+}
+
+func (r *run) updateNext() {
+	r.next = r.nextFlip
+	if r.step >= 0 && r.stepEnd.Before(r.next) {
+		r.next = r.stepEnd
+	}
+	if !r.end.IsZero() && r.end.Before(r.next) {
+		r.next = r.end
+	}
+}
+
+// advance applies whatever is due at r.next.
+func (r *run) advance() {
+	r.now = r.next
+	if !r.end.IsZero() && !r.now.Before(r.end) {
+		r.fadedOut = true
+		r.finish()
+	} else if r.step >= 0 && !r.now.Before(r.stepEnd) {
+		if r.step+1 < len(r.schedule) {
+			r.enterStep(r.step+1, r.stepEnd)
+		} else {
+			r.endOfSchedule()
+		}
+	} else {
+		if !r.item.Blank {
+			r.phase = (r.phase + 1) % r.frameCount()
+		}
+		r.flips++
+		r.nextFlip = r.flipTime(r.flips + 1)
+	}
+	r.updateNext()
+}
+
+// endOfSchedule applies the end policy after the last item.
+func (r *run) endOfSchedule() {
+	r.plays++
+	switch r.onEnd.Action {
+	case LoopForever:
+		r.enterStep(0, r.stepEnd)
+		return
+	case LoopTimes:
+		if r.plays < r.onEnd.Times {
+			r.enterStep(0, r.stepEnd)
+			return
+		}
+	case HoldBlank:
+		r.hold = true
+	}
+	r.finish()
+}
+
+// fadeOut ends the session a fade after at, keeping the steps of a
+// schedule going until then.
+func (r *run) fadeOut(at time.Time) {
+	r.end = at.Add(r.frames(r.ramp.Duration))
+	// The rate only changes after at, so the flips so far stay in place
+	r.nextFlip = r.flipTime(r.flips + 1)
+	r.updateNext()
+}
+
+// finish shows a blank screen until the engine stops.
+func (r *run) finish() {
+	r.finished = true
+	r.end = time.Time{}
+	r.item = ScheduleItem{Blank: true}
+	r.phase = 0
+	r.nextFlip = r.now.Add(never)
+	r.stepEnd = r.nextFlip
+}
+
+// advanceTo applies everything due up to and including t.
+func (r *run) advanceTo(t time.Time) {
+	for !r.finished && !r.next.After(t) {
+		r.advance()
+	}
+	r.now = t
+}
+
+func (r *run) event() Event {
+	var mix float32
+	if r.phase == 1 && r.frameCount() == 2 {
+		mix = 1
+	}
+	return Event{
+		Phase: r.phase,
+		Mix:   mix,
+		Step:  r.step,
+		Blank: r.item.Blank,
+		Item:  r.item,
+		Fade:  r.fade(r.now),
+		Rate:  r.rateAt(r.now),
+		Time:  r.now,
+
+		Finished: r.finished,
+		FadedOut: r.fadedOut,
+	}
+}
+
+// sample returns the state at t including the crossfade of continuous
+// waveforms. t is clamped to the interval before the next change so a
+// late timer never shows the previous step past its end.
+func (r *run) sample(t time.Time) Event {
+	ev := r.event()
+	if ev.Blank {
+		return ev
+	}
+	if t.Before(r.now) {
+		t = r.now
+	}
+	if !t.Before(r.next) {
+		t = r.next.Add(-1)
+	}
+	ev.Fade = r.fade(t)
+	ev.Rate = r.rateAt(t)
+	if r.item.Waveform != Square {
+		ev.Mix = r.item.Waveform.Mix(r.theta(t))
+	}
+	return ev
+}
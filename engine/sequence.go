@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// MinFrameDuration is the shortest display time of a sequence frame, the
+// period of the fastest rate.
+const MinFrameDuration = 10 * time.Millisecond
+
+// Sequence is the ordered set of stimulus frames the engine steps through.
+// Every flip advances to the next frame and the last frame is followed by
+// the first, so two frames alternate as before.
+//
+// With more than two frames or with durations the frames are shown one
+// after the other without crossfading, and the duty cycle and waveform
+// do not apply. Schedule items showing their own stimulus step through its
+// frames instead, following the rate.
+type Sequence struct {
+	Frames    int             // number of frames, 2 when 0
+	Durations []time.Duration // display time of each frame, nil to follow the rate
+}
+
+// Len returns the number of frames.
+func (s Sequence) Len() int {
+	if s.Frames == 0 {
+		return 2
+	}
+	return s.Frames
+}
+
+// stepped reports whether the frames are shown as discrete steps, ignoring
+// the duty cycle and waveform.
+func (s Sequence) stepped() bool {
+	return s.Len() > 2 || len(s.Durations) > 0
+}
+
+// Validate checks the number of frames and their durations.
+func (s Sequence) Validate() error {
+	if s.Len() < 2 {
+		return fmt.Errorf("a sequence needs at least 2 frames, got: %d", s.Frames)
+	}
+	if len(s.Durations) == 0 {
+		return nil
+	}
+	if len(s.Durations) != s.Len() {
+		return fmt.Errorf("%d frame durations for %d frames", len(s.Durations), s.Len())
+	}
+	for i, d := range s.Durations {
+		if d < MinFrameDuration {
+			return fmt.Errorf("frame %d: duration must be at least %s, got: %s", i+1, MinFrameDuration, d)
+		}
+	}
+	return nil
+}
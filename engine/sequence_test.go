@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSequenceValidate(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		seq  Sequence
+		want string // empty for a valid sequence
+	}{
+		{Sequence{}, ""},
+		{Sequence{Frames: 5}, ""},
+		{Sequence{Frames: 3, Durations: []time.Duration{10 * ms, 20 * ms, time.Second}}, ""},
+		{Sequence{Durations: []time.Duration{100 * ms, 100 * ms}}, ""},
+		{Sequence{Frames: 1}, "at least 2 frames"},
+		{Sequence{Frames: -3}, "at least 2 frames"},
+		{Sequence{Frames: 3, Durations: []time.Duration{100 * ms, 100 * ms}}, "2 frame durations for 3 frames"},
+		{Sequence{Durations: []time.Duration{100 * ms}}, "1 frame durations for 2 frames"},
+		{Sequence{Frames: 3, Durations: []time.Duration{100 * ms, 9 * ms, 100 * ms}}, "frame 2: duration must be at least 10ms"},
+		{Sequence{Durations: []time.Duration{100 * ms, -ms}}, "frame 2"},
+	}
+	for _, tt := range tests {
+		err := tt.seq.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", tt.seq, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: %v, want %q", tt.seq, err, tt.want)
+		}
+	}
+}
+
+// flip is a frame expected at a time.
+type flip struct {
+	at    time.Duration
+	phase int
+}
+
+// stepThrough starts e and checks the frames it shows against want.
+func stepThrough(t *testing.T, clock *FakeClock, e *Engine, ch chan Event, want []flip) {
+	t.Helper()
+	e.Start()
+	defer e.Stop()
+	if ev := <-ch; ev.Phase != 0 {
+		t.Fatalf("starts with frame %d, want 0", ev.Phase)
+	}
+	for _, w := range want {
+		advance(clock, e)
+		ev := <-ch
+		if got := ev.Time.Sub(epoch); got != w.at || ev.Phase != w.phase {
+			t.Errorf("frame %d at %v, want frame %d at %v", ev.Phase, got, w.phase, w.at)
+		}
+	}
+}
+
+func TestSequenceSteps(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	if err := e.SetRate(Flips(4)); err != nil {
+		t.Fatal(err)
+	}
+	// Three frames step at the rate; the duty cycle and waveform do not apply
+	if err := e.SetDutyCycle(0.25); err != nil {
+		t.Fatal(err)
+	}
+	e.SetWaveform(Sine)
+	if err := e.SetSequence(Sequence{Frames: 3}); err != nil {
+		t.Fatal(err)
+	}
+	ms := time.Millisecond
+	stepThrough(t, clock, e, ch, []flip{
+		{250 * ms, 1}, {500 * ms, 2}, {750 * ms, 0}, {time.Second, 1}, {1250 * ms, 2}, {1500 * ms, 0},
+	})
+	if e.NeedsFrames() {
+		t.Error("a stepped sequence asks for frames")
+	}
+}
+
+func TestSequenceDurations(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	ms := time.Millisecond
+	durations := []time.Duration{100 * ms, 200 * ms, 300 * ms}
+	if err := e.SetSequence(Sequence{Frames: 3, Durations: durations}); err != nil {
+		t.Fatal(err)
+	}
+	// The engine keeps its own copy
+	durations[0] = time.Hour
+	// Each frame shows for its own duration, whatever the rate
+	stepThrough(t, clock, e, ch, []flip{
+		{100 * ms, 1}, {300 * ms, 2}, {600 * ms, 0}, {700 * ms, 1}, {900 * ms, 2}, {1200 * ms, 0},
+	})
+
+	if err := e.SetSequence(Sequence{Frames: 3, Durations: []time.Duration{100 * ms}}); err == nil {
+		t.Error("set a sequence with a duration missing")
+	}
+	if got := e.Sequence().Durations; len(got) != 3 || got[0] != 100*ms {
+		t.Errorf("durations %v after a rejected sequence", got)
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Sweep selects how the rate of a schedule item changes over its duration.
+type Sweep int
+
+const (
+	NoSweep     Sweep = iota // constant FlickeringRate
+	LinearSweep              // rate changes by the same amount every second
+	LogSweep                 // rate changes by the same factor every second
+)
+
+var sweepNames = []string{"constant", "linear", "log"}
+
+func (s Sweep) String() string {
+	if s < 0 || int(s) >= len(sweepNames) {
+		return fmt.Sprintf("Sweep(%d)", int(s))
+	}
+	return sweepNames[s]
+}
+
+// ParseSweep looks up a sweep kind by name.
+func ParseSweep(name string) (Sweep, error) {
+	for i, n := range sweepNames {
+		if strings.EqualFold(n, name) {
+			return Sweep(i), nil
+		}
+	}
+	return NoSweep, fmt.Errorf("unknown sweep %q", name)
+}
+
+// never is far enough in the future to not matter yet safe to add to a
+// time.Time.
+const never = time.Duration(1 << 62)
+
+// valid reports whether the rates of the item can be flickered.
+func (it ScheduleItem) valid() bool {
+	if _, err := FlipPeriod(it.FlickeringRate.FlipsPerSecond()); err != nil {
+		return false
+	}
+	if it.Sweep != NoSweep {
+		if _, err := FlipPeriod(it.EndRate.FlipsPerSecond()); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sweeping reports whether the rate of the item changes over time.
+func (it ScheduleItem) sweeping() bool {
+	return it.Sweep != NoSweep && it.Duration > 0 && it.EndRate.Hz() != it.FlickeringRate.Hz()
+}
+
+// RateAt returns the instantaneous rate t into the item. Sweeps keep
+// rising or falling past their duration at the same pace.
+func (it ScheduleItem) RateAt(t time.Duration) Rate {
+	if !it.sweeping() {
+		return it.FlickeringRate
+	}
+	f0, f1 := it.FlickeringRate.Hz(), it.EndRate.Hz()
+	x := float64(t) / float64(it.Duration)
+	hz := f0 + (f1-f0)*x
+	if it.Sweep == LogSweep {
+		hz = f0 * math.Pow(f1/f0, x)
+	}
+	return Rate{Value: hz, Unit: Hertz}.In(it.FlickeringRate.Unit)
+}
+
+// cyclesAt returns the number of cycles completed t into the item, the
+// integral of the instantaneous frequency.
+func (it ScheduleItem) cyclesAt(t time.Duration) float64 {
+	f0 := it.FlickeringRate.Hz()
+	s := t.Seconds()
+	if !it.sweeping() {
+		return f0 * s
+	}
+	f1, d := it.EndRate.Hz(), it.Duration.Seconds()
+	if it.Sweep == LogSweep {
+		k := math.Log(f1 / f0)
+		return f0 * d * (math.Exp(k*s/d) - 1) / k
+	}
+	return f0*s + (f1-f0)*s*s/(2*d)
+}
+
+// timeAt is the inverse of cyclesAt: the time into the item at which the
+// given number of cycles has been completed.
+func (it ScheduleItem) timeAt(cycles float64) time.Duration {
+	f0 := it.FlickeringRate.Hz()
+	var s float64
+	switch {
+	case !it.sweeping():
+		s = cycles / f0
+	case it.Sweep == LogSweep:
+		f1, d := it.EndRate.Hz(), it.Duration.Seconds()
+		k := math.Log(f1 / f0)
+		s = d * math.Log1p(cycles*k/(f0*d)) / k
+	default:
+		// Solve a*s^2 + f0*s - cycles = 0 in the form that stays
+		// accurate when a is tiny
+		f1, d := it.EndRate.Hz(), it.Duration.Seconds()
+		a := (f1 - f0) / (2 * d)
+		s = 2 * cycles / (f0 + math.Sqrt(f0*f0+4*a*cycles))
+	}
+	if math.IsNaN(s) || math.IsInf(s, 0) {
+		// A falling sweep never reaches these cycles
+		return never
+	}
+	return time.Duration(math.Round(s * float64(time.Second)))
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func sweep(kind Sweep, from, to float64, d time.Duration) ScheduleItem {
+	return ScheduleItem{Duration: d, FlickeringRate: Hz(from), EndRate: Hz(to), Sweep: kind}
+}
+
+func TestSweepRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		item ScheduleItem
+	}{
+		{"linear rising", sweep(LinearSweep, 1, 10, 10*time.Second)},
+		{"linear falling", sweep(LinearSweep, 10, 2, 10*time.Second)},
+		{"linear equal", sweep(LinearSweep, 5, 5, 10*time.Second)},
+		{"log rising", sweep(LogSweep, 1, 10, 10*time.Second)},
+		{"log falling", sweep(LogSweep, 10, 1, 10*time.Second)},
+		{"log equal", sweep(LogSweep, 5, 5, 10*time.Second)},
+		{"slow linear", sweep(LinearSweep, 40, 40.001, time.Hour)},
+		{"constant", ScheduleItem{Duration: 10 * time.Second, FlickeringRate: Flips(7.3)}},
+	}
+	times := []time.Duration{0, time.Millisecond, 333 * time.Millisecond, time.Second, 3300 * time.Millisecond, 9999 * time.Millisecond, 10 * time.Second}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, d := range times {
+				got := tt.item.timeAt(tt.item.cyclesAt(d))
+				if diff := got - d; diff < -1 || diff > 1 {
+					t.Errorf("timeAt(cyclesAt(%v)) = %v", d, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSweepCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		item   ScheduleItem
+		at     time.Duration
+		cycles float64
+		hz     float64 // rate at the time
+	}{
+		{"linear start", sweep(LinearSweep, 1, 3, 2*time.Second), 0, 0, 1},
+		{"linear middle", sweep(LinearSweep, 1, 3, 2*time.Second), time.Second, 1.5, 2},
+		{"linear end", sweep(LinearSweep, 1, 3, 2*time.Second), 2 * time.Second, 4, 3},
+		{"linear past the end", sweep(LinearSweep, 1, 3, 2*time.Second), 4 * time.Second, 12, 5},
+		{"log middle", sweep(LogSweep, 1, 4, 2*time.Second), time.Second, 1 / math.Log(2), 2},
+		{"log end", sweep(LogSweep, 1, 4, 2*time.Second), 2 * time.Second, 3 / math.Log(2), 4},
+		{"linear falling", sweep(LinearSweep, 4, 2, 2*time.Second), 2 * time.Second, 6, 2},
+		{"log falling", sweep(LogSweep, 4, 1, 2*time.Second), time.Second, 2 / math.Log(2), 2},
+		{"equal", sweep(LogSweep, 5, 5, 2*time.Second), time.Second, 5, 5},
+	}
+	for _, tt := range tests {
+		if got := tt.item.cyclesAt(tt.at); math.Abs(got-tt.cycles) > 1e-9 {
+			t.Errorf("%s: %g cycles at %v, want %g", tt.name, got, tt.at, tt.cycles)
+		}
+		if got := tt.item.RateAt(tt.at).Hz(); math.Abs(got-tt.hz) > 1e-9 {
+			t.Errorf("%s: %g Hz at %v, want %g", tt.name, got, tt.at, tt.hz)
+		}
+	}
+}
+
+func TestSweepNever(t *testing.T) {
+	// A falling linear sweep stops at 0 Hz after 12.5s with 62.5 cycles
+	lin := sweep(LinearSweep, 10, 2, 10*time.Second)
+	if got := lin.timeAt(63); got != never {
+		t.Errorf("linear sweep reaches 63 cycles at %v", got)
+	}
+	// A falling log sweep approaches 100/ln(10), about 43.4 cycles
+	log := sweep(LogSweep, 10, 1, 10*time.Second)
+	if got := log.timeAt(44); got != never {
+		t.Errorf("log sweep reaches 44 cycles at %v", got)
+	}
+}
+
+func TestSweepFlips(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	e, ch := events(clock)
+	// 1 to 3 Hz over 2s, 4 cycles or 8 flips; flip k comes sqrt(1+k)-1
+	// seconds in, the last one together with the end of the step
+	e.SetSchedule(Schedule{
+		Items: []ScheduleItem{sweep(LinearSweep, 1, 3, 2*time.Second)},
+		OnEnd: EndPolicy{Action: StopAtEnd},
+	})
+	e.Start()
+	<-ch
+	for k := 1; k < 8; k++ {
+		advance(clock, e)
+		ev := <-ch
+		want := time.Duration(math.Round((math.Sqrt(1+float64(k)) - 1) * float64(time.Second)))
+		if got := ev.Time.Sub(epoch); got < want-1 || got > want+1 || ev.Phase != k%2 {
+			t.Errorf("flip %d at %v to phase %d, want %v to phase %d", k, got, ev.Phase, want, k%2)
+		}
+		if got, want := ev.Rate.Hz(), 1+ev.Time.Sub(epoch).Seconds(); math.Abs(got-want) > 1e-6 {
+			t.Errorf("flip %d at %g Hz, want %g", k, got, want)
+		}
+	}
+	advance(clock, e)
+	if ev := <-ch; !ev.Finished || ev.Time.Sub(epoch) != 2*time.Second {
+		t.Errorf("%+v after the last flip, want the end of the schedule at 2s", ev)
+	}
+}
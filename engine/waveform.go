@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Waveform is the shape of the luminance modulation between the two
+// images. Square swaps them outright; the other waveforms crossfade.
+type Waveform int
+
+const (
+	DefaultWaveform Waveform = iota // use the session waveform
+	Square
+	Sine
+	Triangle
+	Sawtooth
+)
+
+var waveformNames = []string{"default", "square", "sine", "triangle", "sawtooth"}
+
+func (w Waveform) String() string {
+	if w < 0 || int(w) >= len(waveformNames) {
+		return fmt.Sprintf("Waveform(%d)", int(w))
+	}
+	return waveformNames[w]
+}
+
+// ParseWaveform looks up a waveform by name.
+func ParseWaveform(name string) (Waveform, error) {
+	for i, n := range waveformNames {
+		if i > 0 && strings.EqualFold(n, name) {
+			return Waveform(i), nil
+		}
+	}
+	return DefaultWaveform, fmt.Errorf("unknown waveform %q", name)
+}
+
+// Next returns the waveform after w, used to cycle through them in the UI.
+func (w Waveform) Next() Waveform {
+	if w >= Sawtooth {
+		return Square
+	}
+	return w + 1
+}
+
+// Mix returns the weight of the second image at cycle position theta in
+// [0, 1). The first half of the cycle is where the first image dominates;
+// the duty cycle has already been applied to theta by the caller.
+func (w Waveform) Mix(theta float64) float32 {
+	var first float64
+	switch w {
+	case Sine:
+		first = 0.5 + 0.5*math.Sin(2*math.Pi*theta)
+	case Triangle:
+		switch {
+		case theta < 0.25:
+			first = 0.5 + 2*theta
+		case theta < 0.75:
+			first = 1.5 - 2*theta
+		default:
+			first = 2*theta - 1.5
+		}
+	case Sawtooth:
+		first = 1 - theta
+	default:
+		if theta < 0.5 {
+			first = 1
+		}
+	}
+	return float32(1 - first)
+}
+
+// warp maps a linear position within a cycle to theta so that the first
+// duty share of the cycle covers the first half of the waveform.
+func warp(pos, duty float64) float64 {
+	if pos < duty {
+		return 0.5 * pos / duty
+	}
+	return 0.5 + 0.5*(pos-duty)/(1-duty)
+}
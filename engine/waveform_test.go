@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMix(t *testing.T) {
+	tests := []struct {
+		w     Waveform
+		theta float64
+		want  float32
+	}{
+		{Square, 0, 0},
+		{Square, 0.49, 0},
+		{Square, 0.5, 1},
+		{Square, 0.99, 1},
+		{Sine, 0, 0.5},
+		{Sine, 0.25, 0},
+		{Sine, 0.5, 0.5},
+		{Sine, 0.75, 1},
+		{Sine, 1.0 / 12, 0.25},
+		{Triangle, 0, 0.5},
+		{Triangle, 0.125, 0.25},
+		{Triangle, 0.25, 0},
+		{Triangle, 0.5, 0.5},
+		{Triangle, 0.75, 1},
+		{Triangle, 0.875, 0.75},
+		{Sawtooth, 0, 0},
+		{Sawtooth, 0.5, 0.5},
+		{Sawtooth, 0.75, 0.75},
+	}
+	for _, tt := range tests {
+		if got := tt.w.Mix(tt.theta); math.Abs(float64(got-tt.want)) > 1e-6 {
+			t.Errorf("%v at %g: mix %g, want %g", tt.w, tt.theta, got, tt.want)
+		}
+	}
+}
+
+func TestWarp(t *testing.T) {
+	tests := []struct {
+		pos, duty, want float64
+	}{
+		{0.25, 0.5, 0.25},
+		{0.125, 0.25, 0.25},
+		{0.25, 0.25, 0.5},
+		{0.625, 0.25, 0.75},
+		{0.9, 0.8, 0.75},
+	}
+	for _, tt := range tests {
+		if got := warp(tt.pos, tt.duty); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%g at %g%% duty: theta %g, want %g", tt.pos, tt.duty*100, got, tt.want)
+		}
+	}
+}
+
+func TestParseWaveform(t *testing.T) {
+	for _, w := range []Waveform{Square, Sine, Triangle, Sawtooth} {
+		if got, err := ParseWaveform(w.String()); err != nil || got != w {
+			t.Errorf("%q parsed as %v, %v", w.String(), got, err)
+		}
+	}
+	if got, err := ParseWaveform("SINE"); err != nil || got != Sine {
+		t.Errorf("SINE parsed as %v, %v", got, err)
+	}
+	for _, name := range []string{"default", "saw", ""} {
+		if _, err := ParseWaveform(name); err == nil {
+			t.Errorf("%q parsed without an error", name)
+		}
+	}
+}
+
+// mixAt is the weight of the second image expected at a time.
+type mixAt struct {
+	at  time.Duration
+	mix float32
+}
+
+func TestCrossfade(t *testing.T) {
+	tests := []struct {
+		name string
+		w    Waveform
+		duty float64
+		want []mixAt
+	}{
+		{"sine", Sine, 0.5, []mixAt{{0, 0.5}, {250 * time.Millisecond, 0}, {500 * time.Millisecond, 0.5}, {750 * time.Millisecond, 1}, {1250 * time.Millisecond, 0}}},
+		{"sine at 25%", Sine, 0.25, []mixAt{{125 * time.Millisecond, 0}, {250 * time.Millisecond, 0.5}, {625 * time.Millisecond, 1}}},
+		{"sawtooth", Sawtooth, 0.5, []mixAt{{100 * time.Millisecond, 0.1}, {600 * time.Millisecond, 0.6}, {1900 * time.Millisecond, 0.9}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(epoch)
+			e, _ := events(clock)
+			if err := e.SetRate(Hz(1)); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.SetDutyCycle(tt.duty); err != nil {
+				t.Fatal(err)
+			}
+			e.SetWaveform(tt.w)
+			e.Start()
+			defer e.Stop()
+			if !e.NeedsFrames() {
+				t.Error("a crossfade does not ask for frames")
+			}
+			for _, w := range tt.want {
+				advanceTo(clock, e, epoch.Add(w.at))
+				ev := e.Frame(epoch.Add(w.at))
+				if math.Abs(float64(ev.Mix-w.mix)) > 1e-6 {
+					t.Errorf("mix %g at %v, want %g", ev.Mix, w.at, w.mix)
+				}
+			}
+		})
+	}
+}
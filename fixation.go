@@ -0,0 +1,101 @@
+package main
+
+import (
+	"gio_flicker/attention"
+	"gio_flicker/stimulus"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Fixation mark drawn in the middle of the stimulus area
+type fixationMark struct {
+	spec       *stimulus.Fixation // nil for no mark
+	blanksOnly bool               // hide the mark while the stimulus is shown
+	err        error
+	task       *attention.Task // color changes of the running session, nil for none
+	results    string          // summary of the last attention task
+}
+
+// Read the fixation editor, an empty spec shows no mark
+func parseFixation(ui *UI) {
+	m := &ui.fixation
+	m.spec, m.err = nil, nil
+	text := strings.TrimSpace(ui.fixationEditor.Text())
+	if text == "" {
+		return
+	}
+	f, err := stimulus.ParseFixation(text)
+	if err == nil {
+		// Sizes in degrees need the viewing geometry
+		_, _, err = f.Pixels(ui.viewing)
+	}
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.spec = &f
+}
+
+// Draw the fixation mark in the middle of the area. The mark is black or
+// white, whichever stands out from the background, unless it has a color.
+// A nil background is the unpainted window.
+func drawFixation(gtx layout.Context, ui *UI, background *color.NRGBA) {
+	m := &ui.fixation
+	if m.spec == nil {
+		return
+	}
+	f := *m.spec
+	size, thickness, err := f.Pixels(ui.viewing)
+	if err != nil {
+		return
+	}
+	c := f.Color
+	if c.A == 0 {
+		c = color.NRGBA{A: 255}
+		if background != nil && stimulus.Luminance(*background) < 0.18 {
+			c = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		}
+	}
+	if m.task != nil {
+		target, next := m.task.Show(gtx.Now)
+		if target {
+			c = f.Change
+		}
+		gtx.Execute(op.InvalidateCmd{At: next})
+	}
+
+	center := gtx.Constraints.Max.Div(2)
+	s := max(1, int(math.Round(size)))
+	w := max(1, int(math.Round(thickness)))
+	// Square of side n centered on the area
+	square := func(n int) image.Rectangle {
+		return image.Rectangle{Min: center.Sub(image.Pt(n/2, n/2)), Max: center.Add(image.Pt(n-n/2, n-n/2))}
+	}
+	switch f.Mark {
+	case stimulus.Cross:
+		paint.FillShape(gtx.Ops, c, clip.Rect{
+			Min: center.Sub(image.Pt(s/2, w/2)),
+			Max: center.Add(image.Pt(s-s/2, w-w/2)),
+		}.Op())
+		paint.FillShape(gtx.Ops, c, clip.Rect{
+			Min: center.Sub(image.Pt(w/2, s/2)),
+			Max: center.Add(image.Pt(w-w/2, s-s/2)),
+		}.Op())
+	case stimulus.Dot:
+		paint.FillShape(gtx.Ops, c, clip.Ellipse(square(s)).Op(gtx.Ops))
+	case stimulus.Bullseye:
+		// The ring lies inside the size, around a dot a third as wide
+		ring := square(s).Inset(w / 2)
+		paint.FillShape(gtx.Ops, c, clip.Stroke{
+			Path:  clip.Ellipse(ring).Path(gtx.Ops),
+			Width: float32(w),
+		}.Op())
+		paint.FillShape(gtx.Ops, c, clip.Ellipse(square(max(1, s/3))).Op(gtx.Ops))
+	}
+}
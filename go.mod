@@ -3,13 +3,19 @@ module gio_flicker
 go 1.23
 
 require (
-	gioui.org v0.8.0 // indirect
+	gioui.org v0.8.0
+	gioui.org/x v0.8.1
+	golang.org/x/image v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	gioui.org/shader v1.0.8 // indirect
-	gioui.org/x v0.8.1 // indirect
+	git.wow.st/gmp/jni v0.0.0-20210610011705-34026c7e22d0 // indirect
 	github.com/go-text/typesetting v0.2.1 // indirect
+	github.com/godbus/dbus/v5 v5.0.6 // indirect
 	golang.org/x/exp v0.0.0-20240707233637-46b078467d37 // indirect
 	golang.org/x/exp/shiny v0.0.0-20240707233637-46b078467d37 // indirect
-	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 )
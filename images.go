@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gio_flicker/stimulus"
+	"gioui.org/app"
+	"gioui.org/op/paint"
+	"gioui.org/x/explorer"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Stimulus image formats offered in the file dialog
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// Embedded images used when no file is chosen
+var builtinImages = [2]string{"assets/img1.png", "assets/img2.png"}
+
+// Targets of the file dialog other than a frame of the sequence
+const (
+	sequenceSlot = -1 // several images making up a new sequence
+	blankSlot    = -2 // static image of the blank screen
+)
+
+// Images picked in the file dialog, one for a frame of the sequence, the
+// blank screen or a whole new sequence
+type imageChoice struct {
+	slot  int      // frame to replace, or sequenceSlot or blankSlot
+	paths []string // empty where the file dialog gave no path
+	imgs  []IMG
+	err   error
+}
+
+func decodeImage(r io.Reader) (IMG, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return IMG{}, err
+	}
+	return IMG{
+		imgOp:   paint.NewImageOp(img),
+		imgSize: img.Bounds().Size(),
+		mean:    stimulus.MeanColor(img),
+		src:     img,
+	}, nil
+}
+
+func loadImageFile(path string) (IMG, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return IMG{}, err
+	}
+	defer f.Close()
+	img, err := decodeImage(f)
+	if err != nil {
+		return IMG{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
+
+// All images have to be the same size so the frames line up on screen
+func matchSizes(imgs []IMG) error {
+	for i, img := range imgs[1:] {
+		if img.imgSize != imgs[0].imgSize {
+			return fmt.Errorf("the images must have the same size, image 1 is %dx%d and image %d is %dx%d",
+				imgs[0].imgSize.X, imgs[0].imgSize.Y, i+2, img.imgSize.X, img.imgSize.Y)
+		}
+	}
+	return nil
+}
+
+// Load the stimulus images from paths in the order they are shown. An
+// empty path uses the built-in image for one of the first two frames.
+func loadImages(ui *UI, paths []string) error {
+	if len(paths) < 2 {
+		return fmt.Errorf("a sequence needs at least 2 images, got %d", len(paths))
+	}
+	paths = append([]string(nil), paths...)
+	imgs := make([]IMG, len(paths))
+	for i, path := range paths {
+		var err error
+		switch {
+		case path != "":
+			if path, err = filepath.Abs(path); err == nil {
+				paths[i] = path
+				imgs[i], err = loadImageFile(path)
+			}
+		case i < len(builtinImages):
+			imgs[i], err = loadEmbeddedImage(builtinImages[i])
+		default:
+			err = fmt.Errorf("no file for image %d", i+1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := matchSizes(imgs); err != nil {
+		return err
+	}
+	ui.images = imgs
+	ui.imagePaths = paths
+	ui.imagePending = [2]*imageChoice{}
+	ui.imageErr = nil
+	updateSequence(ui)
+	return nil
+}
+
+// Split a list of image files given on the command line
+func splitImagePaths(list string) []string {
+	var paths []string
+	for _, path := range strings.Split(list, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Open the file dialog for one frame of the sequence or the blank screen,
+// or for several files making up a new sequence. The dialog blocks, so it
+// runs on its own goroutine and hands the result to the draw loop.
+func chooseImage(ui *UI, w *app.Window, slot int) {
+	go func() {
+		c := imageChoice{slot: slot}
+		var files []io.ReadCloser
+		var err error
+		if slot == sequenceSlot {
+			files, err = ui.explorer.ChooseFiles(imageExtensions...)
+		} else {
+			var file io.ReadCloser
+			file, err = ui.explorer.ChooseFile(imageExtensions...)
+			files = []io.ReadCloser{file}
+		}
+		if errors.Is(err, explorer.ErrUserDecline) {
+			return
+		}
+		if err == nil {
+			c.paths, c.imgs, err = decodeFiles(files)
+		}
+		c.err = err
+		ui.imageChoices <- c
+		w.Invalidate()
+	}()
+}
+
+// Decode the files picked in the dialog. On desktop systems every file is
+// an *os.File, its name is kept so the choice can be remembered and
+// several files are shown in the order of their names.
+func decodeFiles(files []io.ReadCloser) ([]string, []IMG, error) {
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	paths := make([]string, len(files))
+	for i, file := range files {
+		if f, ok := file.(interface{ Name() string }); ok {
+			paths[i] = f.Name()
+		}
+	}
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return paths[order[a]] < paths[order[b]] })
+
+	sorted := make([]string, len(files))
+	imgs := make([]IMG, len(files))
+	for i, j := range order {
+		img, err := decodeImage(files[j])
+		if err != nil {
+			if paths[j] != "" {
+				err = fmt.Errorf("%s: %w", paths[j], err)
+			}
+			return nil, nil, err
+		}
+		sorted[i], imgs[i] = paths[j], img
+	}
+	return sorted, imgs, nil
+}
+
+// Apply images picked in the file dialog. An image of another size for one
+// of the first two frames is kept until a matching image is picked for the
+// other one, so a pair of a new size can be chosen one by one.
+func applyImageChoice(ui *UI, c imageChoice) {
+	if c.err != nil {
+		log.Printf("Error loading images: %v", c.err)
+		ui.imageErr = c.err
+		return
+	}
+	if c.slot == blankSlot {
+		setBlankImage(ui, c.paths[0], &c.imgs[0])
+		return
+	}
+	var imgs []IMG
+	var paths []string
+	if c.slot == sequenceSlot {
+		if len(c.imgs) < 2 {
+			ui.imageErr = fmt.Errorf("choose at least 2 images for a sequence, got %d", len(c.imgs))
+			return
+		}
+		imgs, paths = c.imgs, c.paths
+	} else {
+		imgs = append([]IMG(nil), ui.images...)
+		paths = append([]string(nil), ui.imagePaths...)
+		ui.imagePending[c.slot] = &c
+		for i, p := range ui.imagePending {
+			if p != nil {
+				imgs[i], paths[i] = p.imgs[0], p.paths[0]
+			}
+		}
+	}
+	if err := matchSizes(imgs); err != nil {
+		if c.slot >= 0 && len(imgs) == 2 {
+			err = fmt.Errorf("%w, choose a matching image %d", err, 2-c.slot)
+		}
+		ui.imageErr = err
+		return
+	}
+	ui.images = imgs
+	ui.imagePaths = paths
+	ui.imagePending = [2]*imageChoice{}
+	ui.imageErr = nil
+	updateSequence(ui)
+	log.Printf("Stimulus images: %s", imageNames(paths))
+}
+
+// Short name of a stimulus image for labels and logs
+func imageName(path string) string {
+	if path == "" {
+		return "built-in"
+	}
+	return filepath.Base(path)
+}
+
+// Short names of the stimulus images in order, long sequences are cut
+func imageNames(paths []string) string {
+	if len(paths) > 4 {
+		return fmt.Sprintf("%s, %s ... %s (%d images)",
+			imageName(paths[0]), imageName(paths[1]), imageName(paths[len(paths)-1]), len(paths))
+	}
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = imageName(path)
+	}
+	return strings.Join(names, ", ")
+}
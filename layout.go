@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"gioui.org/f32"
+	"gioui.org/io/event"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
@@ -11,6 +14,7 @@ import (
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 	"image"
+	"image/color"
 )
 
 func drawImage(gtx layout.Context, img paint.ImageOp, originalSize image.Point) layout.Dimensions {
@@ -59,23 +63,81 @@ func drawImage(gtx layout.Context, img paint.ImageOp, originalSize image.Point)
 	}
 }
 
-func getImg(ui *UI) IMG {
-	if ui.mainImage == 1 {
-		return ui.img1
+// drawBlend draws the first image and crossfades the second one over it
+// with weight mix, so mix 0 shows only the first image and 1 only the second.
+func drawBlend(gtx layout.Context, first, second IMG, mix float32) layout.Dimensions {
+	if mix <= 0 {
+		return drawImage(gtx, first.imgOp, first.imgSize)
 	}
-	return ui.img2
+	if mix >= 1 {
+		return drawImage(gtx, second.imgOp, second.imgSize)
+	}
+	dims := drawImage(gtx, first.imgOp, first.imgSize)
+	opacity := paint.PushOpacity(gtx.Ops, mix)
+	drawImage(gtx, second.imgOp, second.imgSize)
+	opacity.Pop()
+	return dims
 }
 
-// TODO make this more elegant
-func createLayout(gtx layout.Context,
-	th *material.Theme, startButton, stopButton, setButton, aboutButton,
-	scheduleButton, saveScheduleButton, useScheduleButton *widget.Clickable, ui *UI) layout.Dimensions {
+// drawStimulusArea draws the frame of the current state, or the blank
+// screen, with the fixation mark on top.
+func drawStimulusArea(gtx layout.Context, ui *UI) layout.Dimensions {
+	ui.areaSize = gtx.Constraints.Max
+	// Clicks on the stimulus abort the session
+	defer clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops).Pop()
+	event.Op(gtx.Ops, ui)
+	if ui.aborted != nil {
+		return drawAborted(gtx, *ui.aborted)
+	}
+	state := ui.engine.State()
+	if ui.engine.Running() && !state.Blank {
+		frames := frameImages(ui, state.Item, gtx.Constraints.Max)
+		if len(frames) > 0 {
+			var dims layout.Dimensions
+			if len(frames) == 2 {
+				// Two images follow the waveform, crossfading unless it is square
+				dims = drawBlend(gtx, frames[0], frames[1], state.Mix)
+			} else {
+				frame := frames[state.Phase%len(frames)]
+				dims = drawImage(gtx, frame.imgOp, frame.imgSize)
+			}
+			mean := framesMean(frames)
+			if state.Fade > 0 {
+				// Fading in or out, lower the contrast towards the mean color
+				fade := mean
+				fade.A = uint8(state.Fade*255 + 0.5)
+				paint.FillShape(gtx.Ops, fade, clip.Rect{Max: gtx.Constraints.Max}.Op())
+			}
+			if !ui.fixation.blanksOnly {
+				drawFixation(gtx, ui, &mean)
+			}
+			return dims
+		}
+	}
+	dims := drawBlank(gtx, ui)
+	drawFixation(gtx, ui, blankFill(ui))
+	return dims
+}
+
+func createLayout(gtx layout.Context, th *material.Theme, c *controls, ui *UI) layout.Dimensions {
 
 	// Determine the label for the use schedule button based on the current state
 	useScheduleLabel := "Use Schedule: OFF"
 	if ui.useSchedule {
 		useScheduleLabel = "Use Schedule: ON"
 	}
+	frameLockLabel := "Frame Lock: OFF"
+	if ui.frameLocked {
+		frameLockLabel = "Frame Lock: ON"
+	}
+	fadeRateLabel := "Fade Rate: OFF"
+	if ui.fadeRate {
+		fadeRateLabel = "Fade Rate: ON"
+	}
+	measured := "measured: -"
+	if hz := ui.refreshMeter.Hz(); hz > 0 {
+		measured = fmt.Sprintf("measured: %.1f Hz", hz)
+	}
 
 	return layout.Flex{
 		Axis:      layout.Vertical,
@@ -84,11 +146,9 @@ func createLayout(gtx layout.Context,
 	}.Layout(gtx,
 		// Image container that takes all available space
 		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-			if ui.isTickerRunning.Load() {
-				// Pass the full context constraints to drawImage
-				return drawImage(gtx, getImg(ui).imgOp, getImg(ui).imgSize)
-			}
-			return layout.Dimensions{Size: gtx.Constraints.Max}
+			dims := drawStimulusArea(gtx, ui)
+			drawLimitWarning(gtx, th, ui)
+			return dims
 		}),
 		// Button container - top row (original buttons)
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -100,7 +160,7 @@ func createLayout(gtx layout.Context,
 				}.Layout(gtx,
 					// Label
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-						label := material.Body1(th, "Flicker rate (per second)")
+						label := material.Body1(th, "Flicker rate")
 						label.Alignment = text.Middle
 						return label.Layout(gtx)
 					}),
@@ -112,21 +172,33 @@ func createLayout(gtx layout.Context,
 						editor := material.Editor(th, &ui.rateEditor, "Rate")
 						return editor.Layout(gtx)
 					}),
+					// Rate unit button, flips/s are phase reversals and Hz full cycles
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(80)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.rateUnitButton, ui.rateUnit.String())
+					}),
+					// Duty cycle Editor, share of each cycle showing the first image
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(60)
+						editor := material.Editor(th, &ui.dutyEditor, "Duty %")
+						return editor.Layout(gtx)
+					}),
 
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						gtx.Constraints.Min.X = gtx.Dp(100)
 						gtx.Constraints.Min.Y = gtx.Dp(50)
-						return createButton(gtx, th, startButton, "Start")
+						return createButton(gtx, th, &c.startButton, "Start")
 					}),
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						gtx.Constraints.Min.X = gtx.Dp(100)
 						gtx.Constraints.Min.Y = gtx.Dp(50)
-						return createButton(gtx, th, stopButton, "Stop")
+						return createButton(gtx, th, &c.stopButton, "Stop")
 					}),
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						gtx.Constraints.Min.X = gtx.Dp(100)
 						gtx.Constraints.Min.Y = gtx.Dp(50)
-						return createButton(gtx, th, setButton, "Set")
+						return createButton(gtx, th, &c.setButton, "Set")
 					}),
 
 					layout.Rigid(layout.Spacer{Width: unit.Dp(20)}.Layout),
@@ -134,11 +206,22 @@ func createLayout(gtx layout.Context,
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						gtx.Constraints.Min.X = gtx.Dp(100)
 						gtx.Constraints.Min.Y = gtx.Dp(50)
-						return createButton(gtx, th, aboutButton, "About")
+						return createButton(gtx, th, &c.aboutButton, "About")
 					}),
 				)
 			})
 		}),
+		// Rate and duty cycle errors, shown until valid values are set
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			err := errors.Join(ui.rateErr, ui.dutyErr)
+			if err == nil {
+				return layout.Dimensions{}
+			}
+			label := material.Body2(th, err.Error())
+			label.Color = errorColor
+			label.Alignment = text.Middle
+			return label.Layout(gtx)
+		}),
 		// Schedule editor container
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
@@ -149,7 +232,11 @@ func createLayout(gtx layout.Context,
 				}.Layout(gtx,
 					// Label
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-						label := material.Body1(th, "Schedule:")
+						title := "Schedule:"
+						if ui.scheduleDoc != nil && ui.scheduleDoc.Name != "" {
+							title = "Schedule " + ui.scheduleDoc.Name + ":"
+						}
+						label := material.Body1(th, title)
 						label.Alignment = text.Middle
 						return label.Layout(gtx)
 					}),
@@ -158,7 +245,7 @@ func createLayout(gtx layout.Context,
 					// Schedule Editor
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						gtx.Constraints.Min.X = gtx.Dp(300)
-						editor := material.Editor(th, &ui.scheduleEditor, "Example: 33-3;4;44-3")
+						editor := material.Editor(th, &ui.scheduleEditor, "Example: 16s @ 12Hz; 16s blank")
 						return editor.Layout(gtx)
 					}),
 
@@ -168,21 +255,293 @@ func createLayout(gtx layout.Context,
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						gtx.Constraints.Min.X = gtx.Dp(140)
 						gtx.Constraints.Min.Y = gtx.Dp(50)
-						return createButton(gtx, th, saveScheduleButton, "Save Schedule")
+						return createButton(gtx, th, &c.saveScheduleButton, "Save Schedule")
 					}),
 
 					// Use Schedule button
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						gtx.Constraints.Min.X = gtx.Dp(140)
 						gtx.Constraints.Min.Y = gtx.Dp(50)
-						return createButton(gtx, th, useScheduleButton, useScheduleLabel)
+						return createButton(gtx, th, &c.useScheduleButton, useScheduleLabel)
+					}),
+
+					// Library button, opens the schedule picker below
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.libraryButton, "Library")
+					}),
+				)
+			})
+		}),
+		// Schedule picker, shown while open
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return ui.picker.Layout(gtx, th)
+		}),
+		// Schedule errors, shown until the schedule parses
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if ui.scheduleErr == nil {
+				return layout.Dimensions{}
+			}
+			label := material.Body2(th, ui.scheduleErr.Error())
+			label.Color = errorColor
+			label.Alignment = text.Middle
+			return label.Layout(gtx)
+		}),
+		// Display container
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Spacing:   layout.SpaceEvenly,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					// Label
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(th, "Display refresh (Hz)")
+						label.Alignment = text.Middle
+						return label.Layout(gtx)
+					}),
+					//space between
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					// Refresh Editor
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(60)
+						editor := material.Editor(th, &ui.refreshEditor, "60")
+						return editor.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.Body2(th, measured).Layout(gtx)
+					}),
+
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+
+					// Waveform button, square swaps the images and the others crossfade
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(140)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.waveformButton, "Wave: "+ui.waveform.String())
+					}),
+
+					// Frame Lock button
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(140)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.frameLockButton, frameLockLabel)
+					}),
+
+					layout.Rigid(layout.Spacer{Width: unit.Dp(20)}.Layout),
+
+					// Fade Editor, seconds to fade in and out at the session
+					// and step boundaries
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(60)
+						editor := material.Editor(th, &ui.fadeEditor, "Fade s")
+						return editor.Layout(gtx)
+					}),
+					// Fade Rate button, the rate rises from zero and falls back
+					// with the contrast
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(140)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.fadeRateButton, fadeRateLabel)
 					}),
 				)
 			})
 		}),
+		// Stimulus images container
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Spacing:   layout.SpaceEvenly,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					// Label with the file names
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(th, "Images: "+imageNames(ui.imagePaths))
+						label.Alignment = text.Middle
+						return label.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.image1Button, "Image 1")
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.image2Button, "Image 2")
+					}),
+					// Several images shown in turn
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.sequenceButton, "Sequence")
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.builtinButton, "Built-in")
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					// Frame durations, empty follows the rate
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(120)
+						editor := material.Editor(th, &ui.frameEditor, "Frame ms")
+						return editor.Layout(gtx)
+					}),
+				)
+			})
+		}),
+		// Generated pattern container
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Spacing:   layout.SpaceEvenly,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					// Label
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(th, "Pattern:")
+						label.Alignment = text.Middle
+						return label.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					// Pattern Editor, empty shows the images
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(300)
+						editor := material.Editor(th, &ui.patternEditor, "Images, or e.g. checkerboard 1deg")
+						return editor.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					// Viewing geometry for sizes in degrees
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(80)
+						editor := material.Editor(th, &ui.distanceEditor, "Distance cm")
+						return editor.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(80)
+						editor := material.Editor(th, &ui.densityEditor, "Pixels/cm")
+						return editor.Layout(gtx)
+					}),
+				)
+			})
+		}),
+		// Blank screen container
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			blankImage := "no image"
+			if ui.blank.image != nil {
+				blankImage = imageName(ui.blank.imagePath)
+			}
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Spacing:   layout.SpaceEvenly,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					// Label
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(th, "Blank: "+blankImage)
+						label.Alignment = text.Middle
+						return label.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					// Blank color Editor, empty leaves the area unpainted
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						editor := material.Editor(th, &ui.blankEditor, "mean or #808080")
+						return editor.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.blankImageButton, "Blank Image")
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(100)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.noBlankImageButton, "No Image")
+					}),
+				)
+			})
+		}),
+		// Fixation mark container
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			showLabel := "Fixation: ALWAYS"
+			if ui.fixation.blanksOnly {
+				showLabel = "Fixation: BLANKS"
+			}
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{
+					Axis:      layout.Horizontal,
+					Spacing:   layout.SpaceEvenly,
+					Alignment: layout.Middle,
+				}.Layout(gtx,
+					// Label
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Body1(th, "Fixation:")
+						label.Alignment = text.Middle
+						return label.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					// Fixation Editor, empty shows no mark
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(300)
+						editor := material.Editor(th, &ui.fixationEditor, "None, or e.g. cross 0.5deg color #ff0000")
+						return editor.Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+					// Show the mark over the stimulus too, or on blanks only
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(140)
+						gtx.Constraints.Min.Y = gtx.Dp(50)
+						return createButton(gtx, th, &c.fixationShowButton, showLabel)
+					}),
+					// Results of the last attention task
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						if ui.fixation.results == "" {
+							return layout.Dimensions{}
+						}
+						return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, material.Body2(th, "Last task: "+ui.fixation.results).Layout)
+					}),
+				)
+			})
+		}),
+		// Image, pattern, sequence, blank and fixation errors, such as images of different sizes
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			err := ui.imageErr
+			if err == nil {
+				err = ui.patternErr
+			}
+			if err == nil {
+				err = ui.sequenceErr
+			}
+			if err == nil {
+				err = ui.blank.err
+			}
+			if err == nil {
+				err = ui.fixation.err
+			}
+			if err == nil {
+				return layout.Dimensions{}
+			}
+			label := material.Body2(th, err.Error())
+			label.Color = errorColor
+			label.Alignment = text.Middle
+			return label.Layout(gtx)
+		}),
 		layout.Rigid(layout.Spacer{Height: unit.Dp(20)}.Layout),
 	)
 }
+
+// Color for error messages
+var errorColor = color.NRGBA{R: 200, A: 255}
+
 func createButton(gtx layout.Context, th *material.Theme, buttonWidget *widget.Clickable, buttonText string) layout.Dimensions {
 	btn := material.Button(th, buttonWidget, buttonText)
 	return btn.Layout(gtx)
@@ -0,0 +1,249 @@
+// Package library keeps named schedules in the schedules folder of the
+// config directory next to a set of built-in presets. Every schedule is a YAML
+// schedule.Document in its own file.
+package library
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"gio_flicker/config"
+	"gio_flicker/schedule"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//go:embed presets/*.yaml
+var presets embed.FS
+
+// Entry is a schedule of the library.
+type Entry struct {
+	Name    string
+	Builtin bool // presets can be duplicated but not changed
+	Doc     *schedule.Document
+	path    string
+}
+
+// Library is a directory of user schedules together with the presets.
+type Library struct {
+	dir string
+}
+
+// Open returns the library stored in dir. The directory is created when the
+// first schedule is saved. An empty dir gives a library of presets only.
+func Open(dir string) *Library {
+	return &Library{dir: dir}
+}
+
+// Dir returns the directory of the user schedules, empty for a library of
+// presets only.
+func (l *Library) Dir() string {
+	return l.dir
+}
+
+// List returns the presets followed by the user schedules sorted by name.
+// Files that can't be read are skipped and reported in the error, which
+// comes together with the entries that could be read.
+func (l *Library) List() ([]Entry, error) {
+	var entries []Entry
+	var errs []error
+	files, _ := fs.Glob(presets, "presets/*.yaml")
+	for _, file := range files {
+		data, _ := presets.ReadFile(file)
+		doc, err := schedule.DecodeDocument(data, schedule.YAML)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("preset %s: %w", file, err))
+			continue
+		}
+		entries = append(entries, Entry{Name: doc.Name, Builtin: true, Doc: doc})
+	}
+
+	user, err := l.userEntries()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	sort.Slice(user, func(i, j int) bool {
+		return strings.ToLower(user[i].Name) < strings.ToLower(user[j].Name)
+	})
+	return append(entries, user...), errors.Join(errs...)
+}
+
+func (l *Library) userEntries() ([]Entry, error) {
+	if l.dir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(l.dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	var errs []error
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		var doc *schedule.Document
+		if err == nil {
+			doc, err = schedule.DecodeDocument(data, schedule.YAML)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			continue
+		}
+		entries = append(entries, Entry{Name: doc.Name, Doc: doc, path: file})
+	}
+	return entries, errors.Join(errs...)
+}
+
+// Find returns the entry called name, ignoring case.
+func (l *Library) Find(name string) (Entry, bool) {
+	entries, _ := l.List()
+	for _, e := range entries {
+		if strings.EqualFold(e.Name, name) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Save stores doc under its name, replacing a user schedule of the same
+// name. Presets can't be replaced.
+func (l *Library) Save(doc *schedule.Document) error {
+	name := strings.TrimSpace(doc.Name)
+	if name == "" {
+		return errors.New("the schedule needs a name")
+	}
+	if l.dir == "" {
+		return errors.New("there is no directory to store schedules in")
+	}
+	file := ""
+	if e, ok := l.Find(name); ok {
+		if e.Builtin {
+			return fmt.Errorf("%q is a preset and can't be changed, duplicate it first", e.Name)
+		}
+		file = e.path
+	} else {
+		file = l.newFile(name)
+	}
+	return l.write(file, doc)
+}
+
+func (l *Library) write(file string, doc *schedule.Document) error {
+	data, err := doc.Encode(schedule.YAML)
+	if err != nil {
+		return err
+	}
+	return config.WriteFile(file, data, 0644)
+}
+
+// Duplicate stores a copy of doc as a new user schedule called name, or
+// "<doc name> copy" if name is empty, and returns the copy.
+func (l *Library) Duplicate(doc *schedule.Document, name string) (*schedule.Document, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = l.unusedName(doc.Name + " copy")
+	} else if _, ok := l.Find(name); ok {
+		return nil, fmt.Errorf("a schedule called %q already exists", name)
+	}
+	dup := *doc
+	dup.Name = name
+	dup.Images = append([]string(nil), doc.Images...)
+	dup.Steps = append([]schedule.Step(nil), doc.Steps...)
+	return &dup, l.Save(&dup)
+}
+
+// Rename changes the name of a user schedule.
+func (l *Library) Rename(name, newName string) error {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return errors.New("the schedule needs a name")
+	}
+	e, err := l.userEntry(name)
+	if err != nil {
+		return err
+	}
+	if other, ok := l.Find(newName); ok && other.path != e.path {
+		return fmt.Errorf("a schedule called %q already exists", other.Name)
+	}
+	e.Doc.Name = newName
+	file := e.path
+	if fileName(newName) != fileName(e.Name) {
+		// Keep file names close to the schedule names
+		file = l.newFile(newName)
+	}
+	if err := l.write(file, e.Doc); err != nil {
+		return err
+	}
+	if file != e.path {
+		return os.Remove(e.path)
+	}
+	return nil
+}
+
+// Delete removes a user schedule.
+func (l *Library) Delete(name string) error {
+	e, err := l.userEntry(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(e.path)
+}
+
+func (l *Library) userEntry(name string) (Entry, error) {
+	e, ok := l.Find(name)
+	switch {
+	case !ok:
+		return Entry{}, fmt.Errorf("there is no schedule called %q", name)
+	case e.Builtin:
+		return Entry{}, fmt.Errorf("%q is a preset and can't be changed", e.Name)
+	}
+	return e, nil
+}
+
+// unusedName returns name, or name followed by a number if it is taken.
+func (l *Library) unusedName(name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		if _, ok := l.Find(candidate); !ok {
+			return candidate
+		}
+		candidate = name + " " + strconv.Itoa(i)
+	}
+}
+
+// newFile returns an unused file name for a schedule called name.
+func (l *Library) newFile(name string) string {
+	base := fileName(name)
+	file := filepath.Join(l.dir, base+".yaml")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+			return file
+		}
+		file = filepath.Join(l.dir, base+"-"+strconv.Itoa(i)+".yaml")
+	}
+}
+
+// fileName turns a schedule name into a portable file name, e.g.
+// "40 Hz gamma, 1 hour" into "40-hz-gamma-1-hour".
+func fileName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "schedule"
+	}
+	return b.String()
+}
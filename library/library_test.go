@@ -0,0 +1,294 @@
+package library
+
+import (
+	"gio_flicker/engine"
+	"gio_flicker/limits"
+	"gio_flicker/schedule"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exposure returns the longest continuous flicker of a schedule, where
+// blank steps of at least rest end it, and the flicker of the whole
+// schedule.
+func exposure(t *testing.T, s engine.Schedule, rest time.Duration) (continuous, total time.Duration) {
+	t.Helper()
+	plays := 1
+	switch s.OnEnd.Action {
+	case engine.LoopForever:
+		t.Fatal("the schedule never ends")
+	case engine.LoopTimes:
+		plays = s.OnEnd.Times
+	}
+	var run time.Duration
+	for range plays {
+		for _, item := range s.Items {
+			if !item.Blank {
+				run += item.Duration
+				total += item.Duration
+				continuous = max(continuous, run)
+			} else if item.Duration >= rest {
+				run = 0
+			}
+		}
+	}
+	return continuous, total
+}
+
+func TestPresets(t *testing.T) {
+	entries, err := Open("").List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := presets.ReadDir("presets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(files) || len(entries) == 0 {
+		t.Fatalf("%d presets listed for %d files", len(entries), len(files))
+	}
+	for _, e := range entries {
+		t.Run(e.Name, func(t *testing.T) {
+			if !e.Builtin || e.Name == "" {
+				t.Errorf("entry %+v", e)
+			}
+			s, err := e.Doc.Schedule()
+			if err != nil {
+				t.Fatal(err)
+			}
+			continuous, total := exposure(t, s, limits.Default.Break)
+			if continuous >= limits.Default.MaxContinuous {
+				t.Errorf("%v of continuous flicker, the default limit is %v", continuous, limits.Default.MaxContinuous)
+			}
+			if total >= limits.Default.MaxDaily {
+				t.Errorf("%v of flicker, the default daily limit is %v", total, limits.Default.MaxDaily)
+			}
+		})
+	}
+}
+
+func doc(name string) *schedule.Document {
+	return &schedule.Document{Name: name, Steps: []schedule.Step{{Duration: "10s", Rate: "12Hz"}}}
+}
+
+func names(t *testing.T, l *Library) []string {
+	t.Helper()
+	entries, err := l.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.Builtin {
+			names = append(names, e.Name)
+		}
+	}
+	return names
+}
+
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "schedules")
+	l := Open(dir)
+	if err := l.Save(doc("My Schedule")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(doc("another")); err != nil {
+		t.Fatal(err)
+	}
+	// Saving again replaces the file, found by name in any case
+	d := doc("my schedule")
+	d.Description = "changed"
+	if err := l.Save(d); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, dir); !slices.Equal(got, []string{"another.yaml", "my-schedule.yaml"}) {
+		t.Errorf("files %q", got)
+	}
+	if got := names(t, l); !slices.Equal(got, []string{"another", "my schedule"}) {
+		t.Errorf("schedules %q, sorted by name", got)
+	}
+	if e, ok := l.Find("MY SCHEDULE"); !ok || e.Doc.Description != "changed" || e.Builtin {
+		t.Errorf("found %+v, %v", e, ok)
+	}
+
+	tests := []struct {
+		lib  *Library
+		doc  *schedule.Document
+		want string
+	}{
+		{l, doc("  "), "needs a name"},
+		{l, doc("40 HZ GAMMA, 1 hour"), "is a preset"},
+		{Open(""), doc("x"), "no directory"},
+	}
+	for _, tt := range tests {
+		if err := tt.lib.Save(tt.doc); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("saving %q: %v, want %q", tt.doc.Name, err, tt.want)
+		}
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	l := Open(dir)
+	preset, ok := l.Find("CSF flow on/off")
+	if !ok {
+		t.Fatal("no CSF preset")
+	}
+	for _, want := range []string{"CSF flow on/off copy", "CSF flow on/off copy 2"} {
+		dup, err := l.Duplicate(preset.Doc, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup.Name != want {
+			t.Errorf("copy called %q, want %q", dup.Name, want)
+		}
+	}
+	dup, err := l.Duplicate(preset.Doc, " Mine ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dup.Steps[0].Repeat = 2
+	if preset.Doc.Steps[0].Repeat != 8 {
+		t.Error("changing the copy changed the preset")
+	}
+	if _, err := l.Duplicate(preset.Doc, "mine"); err == nil {
+		t.Error("duplicated onto an existing name")
+	}
+	if got := files(t, dir); !slices.Equal(got, []string{"csf-flow-on-off-copy-2.yaml", "csf-flow-on-off-copy.yaml", "mine.yaml"}) {
+		t.Errorf("files %q", got)
+	}
+	e, _ := l.Find("Mine")
+	a, _ := e.Doc.Schedule()
+	b, _ := preset.Doc.Schedule()
+	if len(a.Items) != len(b.Items) {
+		t.Errorf("copy has %d steps, the preset %d", len(a.Items), len(b.Items))
+	}
+}
+
+func TestRename(t *testing.T) {
+	dir := t.TempDir()
+	l := Open(dir)
+	for _, name := range []string{"first", "second"} {
+		if err := l.Save(doc(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Rename("first", "Third one"); err != nil {
+		t.Fatal(err)
+	}
+	// Only the case changes, the file stays
+	if err := l.Rename("second", "SECOND"); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, dir); !slices.Equal(got, []string{"second.yaml", "third-one.yaml"}) {
+		t.Errorf("files %q", got)
+	}
+	if got := names(t, l); !slices.Equal(got, []string{"SECOND", "Third one"}) {
+		t.Errorf("schedules %q", got)
+	}
+
+	tests := []struct {
+		name, newName, want string
+	}{
+		{"SECOND", "third ONE", "already exists"},
+		{"SECOND", "", "needs a name"},
+		{"missing", "x", "no schedule called"},
+		{"40 Hz gamma, 10 min blocks", "x", "is a preset"},
+		{"SECOND", "40 Hz gamma, 10 min blocks", "already exists"},
+	}
+	for _, tt := range tests {
+		if err := l.Rename(tt.name, tt.newName); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("renaming %q to %q: %v, want %q", tt.name, tt.newName, err, tt.want)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	dir := t.TempDir()
+	l := Open(dir)
+	if err := l.Save(doc("gone")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete("GONE"); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, dir); len(got) != 0 {
+		t.Errorf("files %q left", got)
+	}
+	if err := l.Delete("gone"); err == nil {
+		t.Error("deleted a missing schedule")
+	}
+	if err := l.Delete("CSF flow on/off"); err == nil || !strings.Contains(err.Error(), "is a preset") {
+		t.Errorf("deleting a preset: %v", err)
+	}
+	if _, ok := l.Find("CSF flow on/off"); !ok {
+		t.Error("the preset is gone")
+	}
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"40 Hz gamma, 1 hour", "40-hz-gamma-1-hour"},
+		{"  My  Schedule  ", "my-schedule"},
+		{"../../etc/passwd", "etc-passwd"},
+		{`C:\Windows\x`, "c-windows-x"},
+		{"Übung für Möwen", "übung-für-möwen"},
+		{"a/b:c*d?e", "a-b-c-d-e"},
+		{"///", "schedule"},
+		{"", "schedule"},
+	}
+	for _, tt := range tests {
+		if got := fileName(tt.name); got != tt.want {
+			t.Errorf("fileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Names that give the same file name get a number
+	dir := t.TempDir()
+	l := Open(dir)
+	for _, name := range []string{"A b", "a-b", "A..B"} {
+		if err := l.Save(doc(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := files(t, dir); !slices.Equal(got, []string{"a-b-2.yaml", "a-b-3.yaml", "a-b.yaml"}) {
+		t.Errorf("files %q", got)
+	}
+}
+
+func TestUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	l := Open(dir)
+	if err := l.Save(doc("good")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("steps: [oops"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := l.List()
+	if err == nil || !strings.Contains(err.Error(), "bad.yaml") {
+		t.Errorf("error %v, want one for bad.yaml", err)
+	}
+	if _, ok := l.Find("good"); !ok || len(entries) == 0 {
+		t.Error("the readable schedules are missing")
+	}
+}
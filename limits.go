@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"gio_flicker/config"
+	"gio_flicker/limits"
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"image"
+	"image/color"
+	"log"
+	"time"
+)
+
+// How often the flicker time is saved while flicker runs, so a crash or a
+// killed app loses at most this much of it
+const exposureSaveInterval = time.Minute
+
+// LockoutDialog covers the window while flicker is not allowed
+type LockoutDialog struct {
+	isOpen bool
+	reason string
+	until  time.Time
+}
+
+// Set up the exposure limits and the flicker time tracked so far
+func newLimitTracker(s config.Settings) *limits.Tracker {
+	minutes := func(v float64, def time.Duration) time.Duration {
+		if v < 0 {
+			return 0
+		}
+		if v == 0 {
+			return def
+		}
+		return time.Duration(v * float64(time.Minute))
+	}
+	warning := time.Duration(max(0, s.Limits.WarningS) * float64(time.Second))
+	if s.Limits.WarningS == 0 {
+		warning = limits.Default.Warning
+	}
+	t := &limits.Tracker{
+		Limits: limits.Limits{
+			MaxContinuous: minutes(s.Limits.MaxContinuousMin, limits.Default.MaxContinuous),
+			MaxDaily:      minutes(s.Limits.MaxDailyMin, limits.Default.MaxDaily),
+			Break:         minutes(s.Limits.BreakMin, limits.Default.Break),
+			Warning:       warning,
+		},
+		State: limits.State{
+			Day:        s.Exposure.Day,
+			Daily:      time.Duration(s.Exposure.DailyS * float64(time.Second)),
+			Continuous: time.Duration(s.Exposure.ContinuousS * float64(time.Second)),
+		},
+	}
+	if s.Exposure.LastFlicker != nil {
+		t.State.LastFlicker = *s.Exposure.LastFlicker
+	}
+	if s.Exposure.BreakUntil != nil {
+		t.State.BreakUntil = *s.Exposure.BreakUntil
+	}
+	return t
+}
+
+// Flicker time to remember for the next run
+func exposureSettings(t *limits.Tracker) config.Exposure {
+	e := config.Exposure{
+		Day:         t.State.Day,
+		DailyS:      t.State.Daily.Seconds(),
+		ContinuousS: t.State.Continuous.Seconds(),
+	}
+	if last := t.State.LastFlicker; !last.IsZero() {
+		e.LastFlicker = &last
+	}
+	if until := t.State.BreakUntil; !until.IsZero() {
+		e.BreakUntil = &until
+	}
+	return e
+}
+
+// Count the flicker time of the frame at now, stop the flicker when it
+// reaches a limit and lock it during breaks
+func updateLimits(gtx layout.Context, ui *UI) {
+	state := ui.engine.State()
+	flickering := ui.engine.Running() && !state.Finished && !state.Blank
+	st := ui.limits.Update(gtx.Now, flickering)
+	ui.limitStatus = st
+	if st.Expired {
+		log.Printf("Flicker stopped, the %s limit is reached", st.Limit)
+		stopTicker(ui)
+		flickering = false
+	} else if flickering && st.Limit != limits.NoLimit {
+		if st.Remaining <= ui.limits.Limits.Warning && !ui.limitWarned {
+			ui.limitWarned = true
+			log.Printf("Flicker stops in %s, the %s limit is near", st.Remaining.Round(time.Second), st.Limit)
+		}
+		next := min(time.Second, st.Remaining)
+		gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(next)})
+	}
+	if !flickering || st.Limit == limits.NoLimit || st.Remaining > ui.limits.Limits.Warning {
+		ui.limitWarned = false
+	}
+
+	// Remember the flicker time and any break when the flicker stops and
+	// now and then while it runs, even if the app is killed later
+	if ui.flickering && !flickering || flickering && gtx.Now.Sub(ui.exposureSaved) >= exposureSaveInterval {
+		saveSettings(ui)
+		ui.exposureSaved = gtx.Now
+	}
+	ui.flickering = flickering
+
+	locked, reason, until := ui.limits.Locked(gtx.Now)
+	if locked && !ui.lockout.isOpen {
+		log.Printf("Flicker locked until %s, %s", until.Format(time.DateTime), reason)
+	} else if !locked && ui.lockout.isOpen {
+		log.Printf("Flicker unlocked")
+	}
+	ui.lockout = LockoutDialog{isOpen: locked, reason: reason, until: until}
+	if locked {
+		gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(time.Second)})
+	}
+}
+
+// Show the countdown before the flicker is stopped on top of the stimulus
+func drawLimitWarning(gtx layout.Context, th *material.Theme, ui *UI) {
+	st := ui.limitStatus
+	if !ui.limitWarned || st.Limit == limits.NoLimit {
+		return
+	}
+	label := material.Body1(th, fmt.Sprintf("Flicker stops in %s (%s limit)", formatCountdown(st.Remaining), st.Limit))
+	label.Color = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	layout.N.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(unit.Dp(8)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			macro := op.Record(gtx.Ops)
+			dims := layout.UniformInset(unit.Dp(6)).Layout(gtx, label.Layout)
+			call := macro.Stop()
+			r := gtx.Dp(4)
+			paint.FillShape(gtx.Ops, color.NRGBA{A: 180},
+				clip.RRect{Rect: image.Rectangle{Max: dims.Size}, NE: r, NW: r, SE: r, SW: r}.Op(gtx.Ops))
+			call.Add(gtx.Ops)
+			return dims
+		})
+	})
+}
+
+// Format a time left, e.g. "9:05" or "1:02:03"
+func formatCountdown(d time.Duration) string {
+	s := int(max(0, d).Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func (d *LockoutDialog) Layout(gtx layout.Context, th *material.Theme) layout.Dimensions {
+	if !d.isOpen {
+		return layout.Dimensions{}
+	}
+
+	// Keep clicks from reaching the controls below
+	area := clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops)
+	event.Op(gtx.Ops, d)
+	for {
+		if _, ok := gtx.Event(pointer.Filter{Target: d, Kinds: pointer.Press | pointer.Release}); !ok {
+			break
+		}
+	}
+	area.Pop()
+
+	gtx.Constraints.Min = gtx.Constraints.Max
+
+	return layout.Stack{}.Layout(gtx,
+		layout.Expanded(d.layoutBackground),
+		layout.Stacked(func(gtx layout.Context) layout.Dimensions {
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min = image.Point{X: gtx.Dp(300)}
+				gtx.Constraints.Max.X = min(gtx.Constraints.Max.X, gtx.Dp(560))
+				return layout.Stack{}.Layout(gtx,
+					layout.Expanded(d.layoutPopupBackground),
+					layout.Stacked(d.layoutContent(th)),
+				)
+			})
+		}),
+	)
+}
+
+func (d *LockoutDialog) layoutBackground(gtx layout.Context) layout.Dimensions {
+	paint.Fill(gtx.Ops, color.NRGBA{A: 230})
+	return layout.Dimensions{Size: gtx.Constraints.Min}
+}
+
+func (d *LockoutDialog) layoutPopupBackground(gtx layout.Context) layout.Dimensions {
+	r := gtx.Dp(8)
+	paint.FillShape(gtx.Ops,
+		color.NRGBA{R: 255, G: 255, B: 255, A: 255},
+		clip.RRect{Rect: image.Rectangle{Max: gtx.Constraints.Min}, NE: r, NW: r, SE: r, SW: r}.Op(gtx.Ops))
+	return layout.Dimensions{Size: gtx.Constraints.Min}
+}
+
+func (d *LockoutDialog) layoutContent(th *material.Theme) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(unit.Dp(16)).Layout(gtx,
+			func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						title := material.H6(th, "Take a break")
+						title.Alignment = text.Middle
+						return title.Layout(gtx)
+					}),
+					space(16),
+					layout.Rigid(material.Body1(th, "Flicker is locked because "+d.reason+".").Layout),
+					space(16),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						left := material.H4(th, formatCountdown(time.Until(d.until)))
+						left.Alignment = text.Middle
+						return left.Layout(gtx)
+					}),
+					space(8),
+					layout.Rigid(material.Body2(th, "Available again at "+d.until.Format("Mon 15:04")).Layout),
+				)
+			},
+		)
+	}
+}
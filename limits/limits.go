@@ -0,0 +1,144 @@
+// Package limits caps how long participants are exposed to flicker: the
+// continuous flicker without a break, the total flicker per day, and the
+// rest that has to follow when the continuous maximum is reached.
+//
+// Continuous flicker adds up over sessions until a rest of at least the
+// break length, so stopping and starting again does not reset it. Blank
+// schedule steps count as rest.
+package limits
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits are the maximum flicker times, zero for no limit.
+type Limits struct {
+	MaxContinuous time.Duration // flicker without a break
+	MaxDaily      time.Duration // flicker per calendar day
+	Break         time.Duration // rest that ends continuous flicker, enforced after MaxContinuous
+	Warning       time.Duration // countdown before the flicker is stopped
+}
+
+// Default are the limits that apply unless the settings change them.
+var Default = Limits{
+	MaxContinuous: 60 * time.Minute,
+	MaxDaily:      180 * time.Minute,
+	Break:         15 * time.Minute,
+	Warning:       30 * time.Second,
+}
+
+// State is the flicker time tracked across restarts.
+type State struct {
+	Day         string        // local date the daily time counts for, YYYY-MM-DD
+	Daily       time.Duration // flicker on Day
+	Continuous  time.Duration // flicker since the last break
+	LastFlicker time.Time     // end of the last flicker
+	BreakUntil  time.Time     // end of the enforced break, zero for none
+}
+
+// Limit names the limit that ends the flicker first.
+type Limit int
+
+const (
+	NoLimit Limit = iota
+	Continuous
+	Daily
+)
+
+func (l Limit) String() string {
+	switch l {
+	case Continuous:
+		return "continuous flicker"
+	case Daily:
+		return "daily flicker"
+	}
+	return "no limit"
+}
+
+// Status is the flicker time left at an update.
+type Status struct {
+	Limit     Limit         // the limit that is reached first
+	Remaining time.Duration // flicker left before it, meaningless for NoLimit
+	Expired   bool          // the flicker reached the limit and has to stop
+}
+
+// Tracker measures the flicker time against the limits.
+type Tracker struct {
+	Limits Limits
+	State  State
+
+	flickering bool      // state at the last update
+	last       time.Time // time of the last update
+}
+
+// Update accounts for the time since the last update and reports how much
+// flicker is left. The flicker is taken to have been on or off since the
+// last update as reported then; flickering is its state from now on. A
+// flicker reaching the continuous limit starts the break.
+func (t *Tracker) Update(now time.Time, flickering bool) Status {
+	s := &t.State
+	if !t.flickering && !s.LastFlicker.IsZero() && now.Sub(s.LastFlicker) >= t.Limits.Break {
+		s.Continuous = 0
+	}
+	var d time.Duration
+	if t.flickering && !t.last.IsZero() {
+		d = max(0, now.Sub(t.last))
+	}
+	s.Continuous += d
+	if day := now.Format(time.DateOnly); day != s.Day {
+		// Flicker across midnight counts for the old day until midnight
+		// and for the new day from then on
+		s.Day, s.Daily = day, 0
+		d = min(d, now.Sub(midnight(now)))
+	}
+	s.Daily += d
+	if t.flickering || flickering {
+		s.LastFlicker = now
+	}
+	t.flickering, t.last = flickering, now
+
+	st := t.status()
+	if flickering && st.Limit != NoLimit && st.Remaining <= 0 {
+		st.Expired = true
+		if st.Limit == Continuous {
+			s.BreakUntil = now.Add(t.Limits.Break)
+		}
+	}
+	return st
+}
+
+func (t *Tracker) status() Status {
+	var st Status
+	if t.Limits.MaxContinuous > 0 {
+		st = Status{Limit: Continuous, Remaining: t.Limits.MaxContinuous - t.State.Continuous}
+	}
+	if t.Limits.MaxDaily > 0 {
+		if r := t.Limits.MaxDaily - t.State.Daily; st.Limit == NoLimit || r < st.Remaining {
+			st = Status{Limit: Daily, Remaining: r}
+		}
+	}
+	return st
+}
+
+// Locked reports whether flicker is not allowed at now, why and until
+// when: during the enforced break, and after the daily maximum until the
+// next day.
+func (t *Tracker) Locked(now time.Time) (bool, string, time.Time) {
+	s := t.State
+	if t.Limits.MaxDaily > 0 && s.Day == now.Format(time.DateOnly) && s.Daily >= t.Limits.MaxDaily {
+		return true, fmt.Sprintf("the daily maximum of %s of flicker is reached", t.Limits.MaxDaily),
+			midnight(now).AddDate(0, 0, 1)
+	}
+	if now.Before(s.BreakUntil) {
+		return true, fmt.Sprintf("a break of %s is due after %s of flicker", t.Limits.Break, t.Limits.MaxContinuous),
+			s.BreakUntil
+	}
+	return false, "", time.Time{}
+}
+
+// midnight returns the start of the day of t.
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
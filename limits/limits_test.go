@@ -0,0 +1,140 @@
+package limits
+
+import (
+	"testing"
+	"time"
+)
+
+var testLimits = Limits{
+	MaxContinuous: 60 * time.Minute,
+	MaxDaily:      180 * time.Minute,
+	Break:         15 * time.Minute,
+	Warning:       30 * time.Second,
+}
+
+// day is a date at noon, far from midnight
+var day = time.Date(2024, 3, 14, 12, 0, 0, 0, time.Local)
+
+// flicker runs the tracker from start with flicker on for on and then off,
+// updating every second, and returns the status of the last update.
+func flicker(t *Tracker, start time.Time, on time.Duration) Status {
+	var st Status
+	for d := time.Duration(0); d <= on; d += time.Second {
+		st = t.Update(start.Add(d), d < on)
+	}
+	return st
+}
+
+func TestContinuous(t *testing.T) {
+	tests := []struct {
+		name  string
+		pause time.Duration // between two flickers of 20 minutes
+		want  time.Duration // continuous flicker after the second
+	}{
+		{"short pause", 5 * time.Minute, 40 * time.Minute},
+		{"just short of a break", 15*time.Minute - time.Second, 40 * time.Minute},
+		{"break", 15 * time.Minute, 20 * time.Minute},
+		{"long break", time.Hour, 20 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Tracker{Limits: testLimits}
+			flicker(tr, day, 20*time.Minute)
+			second := day.Add(20*time.Minute + tt.pause)
+			flicker(tr, second, 20*time.Minute)
+			if tr.State.Continuous != tt.want {
+				t.Errorf("continuous %v, want %v", tr.State.Continuous, tt.want)
+			}
+			if tr.State.Daily != 40*time.Minute {
+				t.Errorf("daily %v, want 40m", tr.State.Daily)
+			}
+		})
+	}
+}
+
+func TestContinuousLimit(t *testing.T) {
+	tr := &Tracker{Limits: testLimits}
+	var st Status
+	now := day
+	for ; !st.Expired; now = now.Add(time.Second) {
+		st = tr.Update(now, true)
+		if now.Sub(day) > 2*time.Hour {
+			t.Fatal("the continuous limit never expired")
+		}
+	}
+	end := now.Add(-time.Second)
+	if got := end.Sub(day); got != time.Hour {
+		t.Errorf("expired after %v, want 1h", got)
+	}
+	if st.Limit != Continuous {
+		t.Errorf("limit %v, want %v", st.Limit, Continuous)
+	}
+	tr.Update(end, false)
+
+	tests := []struct {
+		after  time.Duration
+		locked bool
+	}{
+		{0, true},
+		{14 * time.Minute, true},
+		{15*time.Minute - time.Second, true},
+		{15 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		locked, _, until := tr.Locked(end.Add(tt.after))
+		if locked != tt.locked {
+			t.Errorf("%v into the break: locked %v, want %v", tt.after, locked, tt.locked)
+		}
+		if locked && !until.Equal(end.Add(15*time.Minute)) {
+			t.Errorf("locked until %v, want the end of the break", until)
+		}
+	}
+	// The break resets the continuous flicker
+	tr.Update(end.Add(15*time.Minute), true)
+	if tr.State.Continuous != 0 {
+		t.Errorf("continuous %v after the break, want 0", tr.State.Continuous)
+	}
+}
+
+func TestDailyLimit(t *testing.T) {
+	tr := &Tracker{Limits: Limits{MaxDaily: time.Hour, Break: 15 * time.Minute}}
+	st := flicker(tr, day, time.Hour)
+	if st.Limit != Daily || st.Remaining != 0 {
+		t.Errorf("status %+v, want no daily flicker left", st)
+	}
+	locked, _, until := tr.Locked(day.Add(2 * time.Hour))
+	if want := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local); !locked || !until.Equal(want) {
+		t.Errorf("locked %v until %v, want until %v", locked, until, want)
+	}
+	if locked, _, _ := tr.Locked(time.Date(2024, 3, 15, 0, 0, 1, 0, time.Local)); locked {
+		t.Error("still locked the next day")
+	}
+}
+
+func TestMidnight(t *testing.T) {
+	tr := &Tracker{Limits: testLimits}
+	before := time.Date(2024, 3, 14, 23, 50, 0, 0, time.Local)
+	tr.Update(before, true)
+	// A single update 20 minutes later, 10 of them after midnight
+	tr.Update(before.Add(20*time.Minute), true)
+	if tr.State.Day != "2024-03-15" {
+		t.Errorf("day %s, want 2024-03-15", tr.State.Day)
+	}
+	if tr.State.Daily != 10*time.Minute {
+		t.Errorf("daily %v, want the 10m since midnight", tr.State.Daily)
+	}
+	if tr.State.Continuous != 20*time.Minute {
+		t.Errorf("continuous %v, want 20m", tr.State.Continuous)
+	}
+}
+
+func TestNoLimits(t *testing.T) {
+	tr := &Tracker{}
+	st := flicker(tr, day, 5*time.Hour)
+	if st.Limit != NoLimit || st.Expired {
+		t.Errorf("status %+v without limits", st)
+	}
+	if locked, _, _ := tr.Locked(day.Add(5 * time.Hour)); locked {
+		t.Error("locked without limits")
+	}
+}
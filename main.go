@@ -3,81 +3,251 @@ package main
 import (
 	"bytes"
 	"embed"
+	"flag"
+	"fmt"
+	"gio_flicker/config"
+	"gio_flicker/engine"
+	"gio_flicker/library"
+	"gio_flicker/limits"
+	"gio_flicker/schedule"
+	"gio_flicker/stimulus"
 	"gioui.org/app"
+	"gioui.org/io/event"
+	"gioui.org/io/key"
+	"gioui.org/io/pointer"
+	"gioui.org/io/system"
 	"gioui.org/op"
 	"gioui.org/op/paint"
 	"gioui.org/unit"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
+	"gioui.org/x/explorer"
 	"image"
+	"image/color"
 	"log"
 	"os"
-	"sync/atomic"
+	"path/filepath"
+	"strconv"
 	"time"
 )
 
 type UI struct {
-	tickerDone           chan bool
-	t                    *time.Ticker
-	isTickerRunning      atomic.Bool
-	img1                 IMG
-	img2                 IMG
-	mainImage            int
-	flipRate             atomic.Int32
-	cleanedTicker        chan bool
-	rateEditor           widget.Editor
-	aboutDialog          *AboutDialog
-	scheduleEditor       widget.Editor
-	useSchedule          bool
-	schedule             []ScheduleItem
-	currentScheduleIndex int
-	scheduleStartTime    time.Time
+	engine         *engine.Engine
+	images         []IMG // stimulus frames in the order they are shown
+	rateEditor     widget.Editor
+	rateUnit       engine.RateUnit
+	rateErr        error // why the entered rate was not applied
+	dutyEditor     widget.Editor
+	dutyErr        error // why the entered duty cycle was not applied
+	waveform       engine.Waveform
+	aboutDialog    *AboutDialog
+	scheduleEditor widget.Editor
+	useSchedule    bool
+	schedule       engine.Schedule
+	scheduleErr    error
+	schedulePath   string             // file the schedule is loaded from and saved to
+	scheduleDoc    *schedule.Document // metadata of a JSON or YAML schedule
+	library        *library.Library
+	picker         *SchedulePicker
+	refreshEditor  widget.Editor
+	frameLocked    bool
+	fadeEditor     widget.Editor // seconds of the fades in and out, empty for none
+	fadeRate       bool          // the rate fades along with the contrast
+	refreshMeter   engine.RefreshMeter
+	configDir      string          // where settings and schedules are kept, empty if there is none
+	settings       config.Settings // as loaded, updated and saved on exit
+	windowSize     config.Size
+	imagePaths     []string        // stimulus image files, empty for the built-in images
+	imagePending   [2]*imageChoice // picked images waiting for a match of the same size
+	frameEditor    widget.Editor   // frame durations in ms
+	sequenceErr    error
+	imageErr       error
+	imageChoices   chan imageChoice   // images picked in the file dialog
+	explorer       *explorer.Explorer // file dialog
+	patternEditor  widget.Editor
+	pattern        *stimulus.Pattern // generated stimulus, nil to show the images
+	patternErr     error
+	patternImgs    []IMG       // pattern phases rendered at patternSize
+	patternSize    image.Point // zero when the pattern has to be rendered again
+	distanceEditor widget.Editor
+	densityEditor  widget.Editor
+	viewing        stimulus.Viewing
+	stepStimuli    map[string]*stepStimulus // stimuli of schedule steps by their spec
+	blankEditor    widget.Editor
+	blank          blankScreen
+	fixationEditor widget.Editor
+	fixation       fixationMark
+	safety         SafetyDialog
+	consent        ConsentDialog
+	areaSize       image.Point // size of the stimulus area at the last frame
+	sessionStart   time.Time
+	aborted        *color.NRGBA // gray shown after the user aborted the session, nil otherwise
+	limits         *limits.Tracker
+	limitStatus    limits.Status // at the last frame
+	limitWarned    bool          // the countdown before the stop is shown
+	flickering     bool          // flicker was counted at the last frame
+	exposureSaved  time.Time     // when the flicker time was last saved
+	lockout        LockoutDialog
 }
 
 //go:embed assets/*
 var assets embed.FS
 
 func main() {
+	configDir := flag.String("config", "", "config `directory` for settings and schedules, overrides $"+config.EnvVar)
+	image1 := flag.String("image1", "", "first stimulus image `file` (PNG, JPEG, GIF or WebP)")
+	image2 := flag.String("image2", "", "second stimulus image `file`, the same size as the first")
+	images := flag.String("images", "", "comma separated `list` of image files shown in turn, all the same size")
+	schedulePath := flag.String("schedule", "", "schedule `file` to load and save, plain text or a .json, .yaml or .yml document (default schedule.txt in the config directory)")
+	flag.Parse()
 
+	w := new(app.Window)
+
+	lastStep := -1
 	ui := &UI{
-		tickerDone:    make(chan bool, 1),
-		cleanedTicker: make(chan bool),
+		// Redraw whenever the engine changes phase
+		engine: engine.New(engine.SystemClock(), func(ev engine.Event) {
+			if ev.Finished {
+				// The end policy or a fade out ended the session, the UI goes
+				// back to idle unless the final blank screen is held
+				if ev.FadedOut {
+					log.Printf("Flicker faded out")
+				} else {
+					log.Printf("Schedule finished")
+				}
+				lastStep = -1
+				w.Invalidate()
+				return
+			}
+			if ev.Step != lastStep {
+				lastStep = ev.Step
+				if ev.Blank {
+					log.Printf("Schedule step %d: blank", ev.Step+1)
+				} else if ev.Step >= 0 {
+					log.Printf("Schedule step %d: %s", ev.Step+1, describeItem(ev.Item))
+				}
+			}
+			w.Invalidate()
+		}),
 		// Initialize the editor with number-only filter
 		rateEditor: widget.Editor{
 			SingleLine: true,
-			Filter:     "0123456789", // we only want numbers and at most of length of 3 as rate
-			MaxLen:     3,
+			Filter:     "0123456789.", // we only want decimal numbers such as 7.5 as rate
+			MaxLen:     6,
+		},
+		// The schedule language allows one step per line, comments and
+		// repeat blocks, so the editor is multi-line and unlimited
+		scheduleEditor: widget.Editor{},
+		dutyEditor: widget.Editor{
+			SingleLine: true,
+			Filter:     "0123456789.",
+			MaxLen:     5,
 		},
-		scheduleEditor: widget.Editor{
+		refreshEditor: widget.Editor{
 			SingleLine: true,
-			MaxLen:     100,
+			Filter:     "0123456789.",
+			MaxLen:     6,
 		},
-		aboutDialog: NewAboutDialog(),
-		useSchedule: false,
-		schedule:    []ScheduleItem{},
+		fadeEditor: widget.Editor{
+			SingleLine: true,
+			Filter:     "0123456789.",
+			MaxLen:     5,
+		},
+		patternEditor: widget.Editor{
+			SingleLine: true,
+		},
+		distanceEditor: widget.Editor{
+			SingleLine: true,
+			Filter:     "0123456789.",
+			MaxLen:     6,
+		},
+		densityEditor: widget.Editor{
+			SingleLine: true,
+			Filter:     "0123456789.",
+			MaxLen:     6,
+		},
+		frameEditor: widget.Editor{
+			SingleLine: true,
+			Filter:     "0123456789., ",
+		},
+		blankEditor: widget.Editor{
+			SingleLine: true,
+			MaxLen:     7,
+		},
+		fixationEditor: widget.Editor{
+			SingleLine: true,
+		},
+		aboutDialog:  NewAboutDialog(),
+		waveform:     engine.Square,
+		useSchedule:  false,
+		schedulePath: *schedulePath,
+		picker:       NewSchedulePicker(),
+		imageChoices: make(chan imageChoice, 2),
+		explorer:     explorer.NewExplorer(w),
 	}
-	ui.flipRate.Store(1)
-	ui.isTickerRunning.Store(false)
 
-	// Load saved schedule if exists
-	loadSchedule(ui)
-
-	// Load embedded images
+	// Settings and schedules live in the config directory so they are found
+	// whatever directory the app is started from
 	var err error
-	ui.img1, err = loadEmbeddedImage("assets/img1.png")
+	ui.configDir, err = config.Dir(*configDir)
 	if err != nil {
-		log.Fatal(err)
+		// Without a config directory only the presets are available and
+		// nothing is remembered
+		log.Printf("Settings are not saved: %v", err)
+	} else {
+		ui.library = library.Open(filepath.Join(ui.configDir, "schedules"))
+		if ui.schedulePath == "" {
+			ui.schedulePath = config.ScheduleFile(ui.configDir)
+			if old, err := config.MigrateSchedule(ui.configDir); err != nil {
+				log.Printf("Error moving the old schedule file: %v", err)
+			} else if old != "" {
+				log.Printf("Schedule file %s copied to %s", old, ui.schedulePath)
+			}
+		}
+		if ui.settings, err = config.Load(ui.configDir); err != nil {
+			log.Printf("Error loading settings, using defaults: %v", err)
+		}
 	}
-	ui.img2, err = loadEmbeddedImage("assets/img2.png")
-	if err != nil {
-		log.Fatal(err)
+	if ui.library == nil {
+		ui.library = library.Open("")
+	}
+	if ui.schedulePath == "" {
+		ui.schedulePath = "schedule.txt"
+	}
+	applySettings(ui, *schedulePath != "")
+	checkConsent(ui, time.Now())
+
+	// Load the stimulus images given on the command line, else the ones of
+	// the schedule document, else the ones used last time, else the
+	// embedded images
+	if *images != "" {
+		if err := loadImages(ui, splitImagePaths(*images)); err != nil {
+			log.Fatal(err)
+		}
+	} else if *image1 != "" || *image2 != "" {
+		if err := loadImages(ui, []string{*image1, *image2}); err != nil {
+			log.Fatal(err)
+		}
+	} else if ui.scheduleDoc == nil || len(ui.scheduleDoc.Images) == 0 {
+		paths := ui.settings.Images
+		if len(paths) < 2 {
+			paths = []string{"", ""}
+		}
+		if err := loadImages(ui, paths); err != nil {
+			log.Printf("Error loading the last images, using the built-in images: %v", err)
+			if err := loadImages(ui, []string{"", ""}); err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
-	ui.mainImage = 1
 
 	go func() {
-		w := new(app.Window)
 		w.Option(app.Title("Brain flicker"))
-		w.Option(app.Size(unit.Dp(800), unit.Dp(600)))
+		size := ui.settings.Window
+		if size.Width < 200 || size.Height < 200 {
+			size = config.Size{Width: 800, Height: 600}
+		}
+		w.Option(app.Size(unit.Dp(size.Width), unit.Dp(size.Height)))
 
 		if err := draw(w, ui); err != nil {
 			log.Fatal(err)
@@ -88,74 +258,281 @@ func main() {
 	app.Main()
 }
 
+// Clickable widgets of the main window
+type controls struct {
+	startButton        widget.Clickable
+	stopButton         widget.Clickable
+	setButton          widget.Clickable
+	aboutButton        widget.Clickable
+	saveScheduleButton widget.Clickable
+	useScheduleButton  widget.Clickable
+	frameLockButton    widget.Clickable
+	rateUnitButton     widget.Clickable
+	waveformButton     widget.Clickable
+	libraryButton      widget.Clickable
+	image1Button       widget.Clickable
+	image2Button       widget.Clickable
+	builtinButton      widget.Clickable
+	sequenceButton     widget.Clickable
+	blankImageButton   widget.Clickable
+	noBlankImageButton widget.Clickable
+	fixationShowButton widget.Clickable
+	fadeRateButton     widget.Clickable
+}
+
 type IMG struct {
 	imgOp   paint.ImageOp
 	imgSize image.Point
-}
-
-type ScheduleItem struct {
-	Duration       int // in seconds
-	FlickeringRate int // flips per second
-	BlankTime      int // in seconds, 0 if not a blank period
+	mean    color.NRGBA // mean color in linear light, for blanks matching the stimulus
+	src     image.Image // pixels, for the safety check
 }
 
 func draw(w *app.Window, ui *UI) error {
 	var ops op.Ops
-	startButton := new(widget.Clickable)
-	stopButton := new(widget.Clickable)
-	setButton := new(widget.Clickable)
-	aboutButton := new(widget.Clickable)
-	scheduleButton := new(widget.Clickable)     // Add schedule button
-	saveScheduleButton := new(widget.Clickable) // Add save schedule button
-	useScheduleButton := new(widget.Clickable)  // Add use schedule button
+	c := new(controls)
 	th := material.NewTheme()
 
 	for {
 		evt := w.Event()
+		ui.explorer.ListenEvents(evt)
 		switch e := evt.(type) {
 		case app.FrameEvent:
 			gtx := app.NewContext(&ops, e)
+			ui.refreshMeter.Observe(e.Now)
+			ui.windowSize = config.Size{
+				Width:  float32(gtx.Metric.PxToDp(e.Size.X)),
+				Height: float32(gtx.Metric.PxToDp(e.Size.Y)),
+			}
 
-			if startButton.Clicked(gtx) {
-				startTicker(ui, w)
+			if c.startButton.Clicked(gtx) {
+				startTicker(ui)
+				// Leave the keys to the attention task instead of the
+				// focused button or editor
+				gtx.Execute(key.FocusCmd{})
 			}
-			if stopButton.Clicked(gtx) {
-				stopTicker(ui)
+			if c.stopButton.Clicked(gtx) {
+				fadeOutTicker(ui)
 			}
-			if setButton.Clicked(gtx) {
-				changeRate(ui, w)
+			if c.setButton.Clicked(gtx) {
+				changeRate(ui)
 			}
-			if aboutButton.Clicked(gtx) {
+			if c.rateUnitButton.Clicked(gtx) {
+				// Switch what the entered number means and apply it
+				if ui.rateUnit == engine.Hertz {
+					ui.rateUnit = engine.FlipsPerSecond
+				} else {
+					ui.rateUnit = engine.Hertz
+				}
+				changeRate(ui)
+				// Old style schedules read rates without a unit in this unit
+				parseSchedule(ui, ui.scheduleEditor.Text())
+			}
+			if c.waveformButton.Clicked(gtx) {
+				ui.waveform = ui.waveform.Next()
+				changeRate(ui)
+			}
+			if c.aboutButton.Clicked(gtx) {
 				ui.aboutDialog.isOpen = true
 			}
 			if ui.aboutDialog.closeButton.Clicked(gtx) {
 				ui.aboutDialog.isOpen = false
 			}
-			if saveScheduleButton.Clicked(gtx) {
+			if ui.consent.continueButton.Clicked(gtx) {
+				acknowledgeConsent(ui, time.Now())
+			}
+			if ui.consent.quitButton.Clicked(gtx) {
+				log.Printf("Health warning not acknowledged, quitting")
+				w.Perform(system.ActionClose)
+			}
+			if ui.safety.cancelButton.Clicked(gtx) {
+				ui.safety.isOpen = false
+				log.Printf("Session with a seizure risk cancelled by the user")
+			}
+			if ui.safety.overrideButton.Clicked(gtx) {
+				overrideSafety(ui)
+				gtx.Execute(key.FocusCmd{})
+			}
+			for {
+				// Check the schedule while it is typed so errors show up at once
+				ev, ok := ui.scheduleEditor.Update(gtx)
+				if !ok {
+					break
+				}
+				if _, ok := ev.(widget.ChangeEvent); ok {
+					parseSchedule(ui, ui.scheduleEditor.Text())
+				}
+			}
+			if c.saveScheduleButton.Clicked(gtx) {
 				// Parse and save the schedule
 				parseSchedule(ui, ui.scheduleEditor.Text())
 				saveSchedule(ui)
 			}
-			if useScheduleButton.Clicked(gtx) {
-				// Toggle the use schedule flag
-				ui.useSchedule = !ui.useSchedule
+			if c.useScheduleButton.Clicked(gtx) {
+				// Toggle the use schedule flag, a schedule with errors can't be used
+				ui.useSchedule = !ui.useSchedule && ui.scheduleErr == nil
 
 				// If we're running, restart with the new setting
-				if ui.isTickerRunning.Load() {
+				if ui.engine.Running() {
 					stopTicker(ui)
-					<-ui.cleanedTicker
-					startTicker(ui, w)
+					startTicker(ui)
 				}
 			}
+			if c.libraryButton.Clicked(gtx) {
+				ui.picker.isOpen = !ui.picker.isOpen
+				if ui.picker.isOpen {
+					ui.picker.refresh(ui.library)
+				}
+			}
+			updatePicker(gtx, ui)
+			if c.image1Button.Clicked(gtx) {
+				chooseImage(ui, w, 0)
+			}
+			if c.image2Button.Clicked(gtx) {
+				chooseImage(ui, w, 1)
+			}
+			if c.sequenceButton.Clicked(gtx) {
+				chooseImage(ui, w, -1)
+			}
+			if c.builtinButton.Clicked(gtx) {
+				if err := loadImages(ui, []string{"", ""}); err != nil {
+					ui.imageErr = err
+				}
+			}
+			if c.blankImageButton.Clicked(gtx) {
+				chooseImage(ui, w, blankSlot)
+			}
+			if c.noBlankImageButton.Clicked(gtx) {
+				setBlankImage(ui, "", nil)
+			}
+			if c.fixationShowButton.Clicked(gtx) {
+				ui.fixation.blanksOnly = !ui.fixation.blanksOnly
+			}
+			for {
+				ev, ok := ui.fixationEditor.Update(gtx)
+				if !ok {
+					break
+				}
+				if _, ok := ev.(widget.ChangeEvent); ok {
+					parseFixation(ui)
+				}
+			}
+			for {
+				ev, ok := ui.blankEditor.Update(gtx)
+				if !ok {
+					break
+				}
+				if _, ok := ev.(widget.ChangeEvent); ok {
+					parseBlankColor(ui)
+				}
+			}
+			for len(ui.imageChoices) > 0 {
+				applyImageChoice(ui, <-ui.imageChoices)
+			}
+			for {
+				ev, ok := ui.patternEditor.Update(gtx)
+				if !ok {
+					break
+				}
+				if _, ok := ev.(widget.ChangeEvent); ok {
+					parsePattern(ui)
+				}
+			}
+			for _, ed := range []*widget.Editor{&ui.distanceEditor, &ui.densityEditor} {
+				for {
+					ev, ok := ed.Update(gtx)
+					if !ok {
+						break
+					}
+					if _, ok := ev.(widget.ChangeEvent); ok {
+						parseViewing(ui)
+					}
+				}
+			}
+			for {
+				ev, ok := ui.frameEditor.Update(gtx)
+				if !ok {
+					break
+				}
+				if _, ok := ev.(widget.ChangeEvent); ok {
+					updateSequence(ui)
+				}
+			}
+			releaseButtonFocus(gtx, ui)
+			// Escape, Space or a click on the stimulus stop the session at
+			// once, Enter responds in the attention task. Space and Enter are
+			// left to a focused editor.
+			filters := []event.Filter{
+				key.Filter{Name: key.NameEscape},
+				pointer.Filter{Target: ui, Kinds: pointer.Press},
+			}
+			if !editorFocused(gtx, ui) {
+				filters = append(filters,
+					key.Filter{Name: key.NameSpace},
+					key.Filter{Name: key.NameReturn},
+					key.Filter{Name: key.NameEnter},
+				)
+			}
+			for {
+				ev, ok := gtx.Event(filters...)
+				if !ok {
+					break
+				}
+				switch ev := ev.(type) {
+				case key.Event:
+					if ev.State != key.Press {
+						break
+					}
+					switch ev.Name {
+					case key.NameEscape:
+						abortSession(ui, "the Escape key")
+					case key.NameSpace:
+						abortSession(ui, "the Space key")
+					default:
+						respondAttention(ui, time.Now())
+					}
+				case pointer.Event:
+					abortSession(ui, "a click on the stimulus")
+				}
+			}
+			if ui.fixation.task != nil && (!ui.engine.Running() || ui.engine.State().Finished) {
+				// The schedule ended the session
+				endAttentionTask(ui, time.Now())
+			}
+			if c.fadeRateButton.Clicked(gtx) {
+				ui.fadeRate = !ui.fadeRate
+			}
+			if c.frameLockButton.Clicked(gtx) {
+				ui.frameLocked = !ui.frameLocked
+				if ui.engine.Running() {
+					stopTicker(ui)
+					startTicker(ui)
+				}
+			}
+
+			// In frame-locked mode every frame decides the phase and
+			// continuous waveforms crossfade on every frame, so keep frames
+			// coming while the engine runs
+			if ui.engine.NeedsFrames() {
+				ui.engine.Frame(e.Now)
+				gtx.Execute(op.InvalidateCmd{})
+			}
+
+			// Check changes to the stimulus before they are drawn
+			recheckSafety(ui)
+			updateLimits(gtx, ui)
 
 			// Create a flex layout for the entire window
-			createLayout(gtx, th, startButton, stopButton, setButton, aboutButton,
-				scheduleButton, saveScheduleButton, useScheduleButton, ui)
+			createLayout(gtx, th, c, ui)
 			ui.aboutDialog.Layout(gtx, th)
+			ui.safety.Layout(gtx, th)
+			ui.lockout.Layout(gtx, th)
+			ui.consent.Layout(gtx, th)
 
 			e.Frame(gtx.Ops)
 
 		case app.DestroyEvent:
+			endAttentionTask(ui, time.Now())
+			saveSettings(ui)
 			return e.Err
 		}
 	}
@@ -179,18 +556,35 @@ func loadEmbeddedImage(path string) (IMG, error) {
 	return IMG{
 		imgOp:   paint.NewImageOp(img),
 		imgSize: img.Bounds().Size(),
+		mean:    stimulus.MeanColor(img),
+		src:     img,
 	}, nil
 }
 
 // Load schedule from file
 func loadSchedule(ui *UI) {
 	// Try to read the schedule file
-	data, err := os.ReadFile("schedule.txt")
+	data, err := os.ReadFile(ui.schedulePath)
 	if err != nil {
 		// File doesn't exist or error reading, just return without loading
 		return
 	}
 
+	if enc, ok := schedule.EncodingOf(ui.schedulePath); ok {
+		// Documents carry metadata next to the steps, keep it for saving
+		doc, err := schedule.DecodeDocument(data, enc)
+		if err == nil {
+			err = useDocument(ui, doc, filepath.Dir(ui.schedulePath))
+		}
+		if err != nil {
+			log.Printf("Error loading schedule %s: %v", ui.schedulePath, err)
+			ui.scheduleErr = err
+			return
+		}
+		log.Printf("Loaded schedule %q from %s", doc.Name, ui.schedulePath)
+		return
+	}
+
 	// Set the schedule editor text
 	scheduleText := string(data)
 	ui.scheduleEditor.SetText(scheduleText)
@@ -198,3 +592,29 @@ func loadSchedule(ui *UI) {
 	// Parse the schedule
 	parseSchedule(ui, scheduleText)
 }
+
+// Put the steps of a schedule document into the editor, load its stimulus
+// images from paths relative to dir and keep its metadata for saving
+func useDocument(ui *UI, doc *schedule.Document, dir string) error {
+	scheduleText, err := doc.Text()
+	if err != nil {
+		return err
+	}
+	if paths := doc.ImagePaths(dir); len(paths) > 0 {
+		if err := loadImages(ui, paths); err != nil {
+			return fmt.Errorf("images of the schedule: %w", err)
+		}
+		log.Printf("Images of the schedule: %s", imageNames(ui.imagePaths))
+	}
+	if err := doc.CheckFiles(dir); err != nil {
+		// Reported again when a session is started without them
+		log.Printf("Schedule %q refers to missing files: %v", doc.Name, err)
+	}
+	ui.scheduleDoc = doc
+	if doc.RefreshHz > 0 && ui.refreshEditor.Text() == "" {
+		ui.refreshEditor.SetText(strconv.FormatFloat(doc.RefreshHz, 'f', -1, 64))
+	}
+	ui.scheduleEditor.SetText(scheduleText)
+	parseSchedule(ui, scheduleText)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"gio_flicker/stimulus"
+	"gioui.org/op/paint"
+	"image"
+	"image/color"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Read the pattern editor. An empty spec shows the stimulus images.
+func parsePattern(ui *UI) {
+	defer updateSequence(ui)
+	spec := strings.TrimSpace(ui.patternEditor.Text())
+	ui.pattern, ui.patternErr = nil, nil
+	ui.patternSize = image.Point{}
+	if spec == "" {
+		return
+	}
+	p, err := stimulus.Parse(spec)
+	if err != nil {
+		ui.patternErr = err
+		return
+	}
+	ui.pattern = &p
+}
+
+// Read the viewing distance and pixel density used for sizes in degrees
+func parseViewing(ui *UI) {
+	ui.viewing.DistanceCM, _ = strconv.ParseFloat(ui.distanceEditor.Text(), 64)
+	ui.viewing.PixelsPerCM, _ = strconv.ParseFloat(ui.densityEditor.Text(), 64)
+	// Sizes in degrees change with the geometry
+	ui.patternSize = image.Point{}
+	for _, st := range ui.stepStimuli {
+		st.size = image.Point{}
+	}
+	parseFixation(ui)
+	ui.safety.stale = true
+}
+
+// Return the frames to show in an area of size pixels. Patterns are
+// rendered again whenever the area changes size so they are always drawn
+// pixel for pixel.
+func stimulusImages(ui *UI, size image.Point) []IMG {
+	if ui.pattern == nil || size.X <= 0 || size.Y <= 0 {
+		return ui.images
+	}
+	if size != ui.patternSize {
+		ui.patternSize = size
+		frames, err := ui.pattern.Render(size, ui.viewing)
+		ui.patternErr = err
+		ui.patternImgs = nil
+		gray := ui.pattern.MeanGray()
+		for _, frame := range frames {
+			ui.patternImgs = append(ui.patternImgs, IMG{
+				imgOp:   paint.NewImageOp(frame),
+				imgSize: size,
+				mean:    color.NRGBA{R: gray, G: gray, B: gray, A: 255},
+				src:     frame,
+			})
+		}
+		if err == nil {
+			log.Printf("Pattern %s rendered at %dx%d", ui.pattern, size.X, size.Y)
+		}
+	}
+	if ui.patternErr != nil {
+		return ui.images
+	}
+	return ui.patternImgs
+}
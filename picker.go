@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"gio_flicker/library"
+	"gio_flicker/schedule"
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"log"
+)
+
+// SchedulePicker is the drop-down below the schedule editor that picks,
+// duplicates, renames and deletes schedules of the library.
+type SchedulePicker struct {
+	isOpen          bool
+	entries         []library.Entry
+	entryButtons    []widget.Clickable
+	list            widget.List
+	selected        string // name of the picked entry, empty if none
+	nameEditor      widget.Editor
+	duplicateButton widget.Clickable
+	renameButton    widget.Clickable
+	deleteButton    widget.Clickable
+	err             error
+}
+
+func NewSchedulePicker() *SchedulePicker {
+	return &SchedulePicker{
+		list:       widget.List{List: layout.List{Axis: layout.Vertical}},
+		nameEditor: widget.Editor{SingleLine: true},
+	}
+}
+
+// refresh reads the library again after it changed.
+func (p *SchedulePicker) refresh(lib *library.Library) {
+	entries, err := lib.List()
+	if err != nil {
+		// Broken files are left out, the rest of the library still works
+		log.Printf("Error reading the schedule library: %v", err)
+	}
+	p.entries = entries
+	p.entryButtons = make([]widget.Clickable, len(entries))
+}
+
+// updatePicker handles the clicks of the open picker.
+func updatePicker(gtx layout.Context, ui *UI) {
+	p := ui.picker
+	if !p.isOpen {
+		return
+	}
+	for i := range p.entryButtons {
+		if p.entryButtons[i].Clicked(gtx) {
+			pickSchedule(ui, p.entries[i])
+		}
+	}
+	name := p.nameEditor.Text()
+	if p.duplicateButton.Clicked(gtx) {
+		// Duplicate whatever is in the editor, so this also adds a new
+		// schedule to the library
+		p.err = ui.scheduleErr
+		if p.err == nil {
+			doc := currentDocument(ui)
+			p.err = doc.SetText(ui.scheduleEditor.Text(), ui.rateUnit)
+			if p.err == nil {
+				doc, p.err = ui.library.Duplicate(doc, name)
+			}
+			if p.err == nil {
+				log.Printf("Schedule %q added to the library", doc.Name)
+				p.refresh(ui.library)
+				if e, ok := ui.library.Find(doc.Name); ok {
+					pickSchedule(ui, e)
+				}
+			}
+		}
+	}
+	if p.renameButton.Clicked(gtx) && p.checkSelected() {
+		p.err = ui.library.Rename(p.selected, name)
+		if p.err == nil {
+			log.Printf("Schedule %q renamed to %q", p.selected, name)
+			p.selected = name
+			ui.scheduleDoc.Name = name
+			p.refresh(ui.library)
+		}
+	}
+	if p.deleteButton.Clicked(gtx) && p.checkSelected() {
+		p.err = ui.library.Delete(p.selected)
+		if p.err == nil {
+			log.Printf("Schedule %q deleted from the library", p.selected)
+			p.selected = ""
+			p.nameEditor.SetText("")
+			p.refresh(ui.library)
+		}
+	}
+}
+
+// checkSelected reports whether a schedule is picked to work on.
+func (p *SchedulePicker) checkSelected() bool {
+	if p.selected == "" {
+		p.err = errors.New("pick a schedule of the library first")
+		return false
+	}
+	return true
+}
+
+// currentDocument returns a copy of the document being edited, or a new
+// one for a schedule typed into the editor.
+func currentDocument(ui *UI) *schedule.Document {
+	if ui.scheduleDoc == nil {
+		return &schedule.Document{Name: "Schedule"}
+	}
+	doc := *ui.scheduleDoc
+	return &doc
+}
+
+// pickSchedule puts a schedule of the library into the editor.
+func pickSchedule(ui *UI, e library.Entry) {
+	doc := *e.Doc
+	if err := useDocument(ui, &doc, ui.library.Dir()); err != nil {
+		ui.picker.err = err
+		return
+	}
+	ui.picker.selected = e.Name
+	ui.picker.nameEditor.SetText(e.Name)
+	ui.picker.err = nil
+	log.Printf("Schedule %q picked from the library", e.Name)
+}
+
+func (p *SchedulePicker) Layout(gtx layout.Context, th *material.Theme) layout.Dimensions {
+	if !p.isOpen {
+		return layout.Dimensions{}
+	}
+	return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		gtx.Constraints.Max.X = gtx.Dp(600)
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			// Schedules of the library, presets first
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Max.Y = gtx.Dp(150)
+				return material.List(th, &p.list).Layout(gtx, len(p.entries), func(gtx layout.Context, i int) layout.Dimensions {
+					return material.Clickable(gtx, &p.entryButtons[i], func(gtx layout.Context) layout.Dimensions {
+						e := p.entries[i]
+						title := e.Name
+						if e.Builtin {
+							title += " (preset)"
+						}
+						label := material.Body1(th, title)
+						if e.Name == p.selected {
+							label.Font.Weight = font.Bold
+						}
+						return layout.UniformInset(unit.Dp(4)).Layout(gtx, label.Layout)
+					})
+				})
+			}),
+			// Name and actions for the picked schedule
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return material.Editor(th, &p.nameEditor, "Name").Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return createButton(gtx, th, &p.duplicateButton, "Duplicate")
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return createButton(gtx, th, &p.renameButton, "Rename")
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return createButton(gtx, th, &p.deleteButton, "Delete")
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if p.err == nil {
+					return layout.Dimensions{}
+				}
+				label := material.Body2(th, p.err.Error())
+				label.Color = errorColor
+				return label.Layout(gtx)
+			}),
+		)
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"gio_flicker/engine"
+	"gio_flicker/safety"
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"image"
+	"image/color"
+	"log"
+	"time"
+)
+
+// SafetyDialog shows why a session exceeds the photosensitive seizure
+// thresholds and lets the user run it anyway
+type SafetyDialog struct {
+	isOpen         bool
+	report         safety.Report
+	approved       string // report of the session the user chose to run anyway
+	stale          bool   // the stimulus changed and has to be checked again
+	cancelButton   widget.Clickable
+	overrideButton widget.Clickable
+}
+
+// Check the session about to run against the seizure thresholds. A session
+// exceeding them only runs after the user overrides the check for exactly
+// this session, otherwise the dialog opens.
+func safetyApproved(ui *UI) bool {
+	report := safety.Analyze(safetySession(ui), safety.Default)
+	if report.Safe() {
+		return true
+	}
+	if report.String() == ui.safety.approved {
+		log.Printf("Safety check overridden by the user, the session exceeds the seizure thresholds")
+		return true
+	}
+	log.Printf("The session exceeds the photosensitive seizure thresholds and needs an override to run:\n%s", report)
+	ui.safety.report = report
+	ui.safety.isOpen = true
+	return false
+}
+
+// Run the session of the open dialog anyway
+func overrideSafety(ui *UI) {
+	d := &ui.safety
+	d.isOpen = false
+	d.approved = d.report.String()
+	log.Printf("Safety override: the user chose to run a session exceeding the seizure thresholds at %s:\n%s",
+		time.Now().Format(time.RFC3339), d.approved)
+	startTicker(ui)
+}
+
+// Check the running session again after its stimulus changed, and stop it
+// when it needs an override
+func recheckSafety(ui *UI) {
+	if !ui.safety.stale {
+		return
+	}
+	ui.safety.stale = false
+	if ui.engine.Running() && !ui.engine.State().Finished && !safetyApproved(ui) {
+		log.Printf("Flicker stopped, the changed stimulus exceeds the seizure thresholds")
+		stopTicker(ui)
+	}
+}
+
+// Describe the session that startTicker runs for the safety check: every
+// schedule step, or else the flicker at the session rate without an end
+func safetySession(ui *UI) safety.Session {
+	if !ui.useSchedule || len(ui.schedule.Items) == 0 {
+		return safety.Session{Segments: []safety.Segment{{
+			Name:           "flicker",
+			Frames:         frameSources(stimulusImages(ui, ui.areaSize)),
+			FlipsPerSecond: flipsPerSecond(ui, engine.ScheduleItem{FlickeringRate: ui.engine.Rate()}),
+		}}}
+	}
+	var s safety.Session
+	switch end := ui.schedule.OnEnd; end.Action {
+	case engine.StopAtEnd, engine.HoldBlank:
+		s.Plays = 1
+	case engine.LoopTimes:
+		s.Plays = end.Times
+	}
+	for i, item := range ui.schedule.Items {
+		seg := safety.Segment{Name: fmt.Sprintf("step %d", i+1), Duration: item.Duration}
+		if !item.Blank {
+			seg.Frames = frameSources(frameImages(ui, item, ui.areaSize))
+			seg.FlipsPerSecond = flipsPerSecond(ui, item)
+		}
+		s.Segments = append(s.Segments, seg)
+	}
+	return s
+}
+
+// Highest rate of frame changes of an item, frame durations of the session
+// sequence replace the rate
+func flipsPerSecond(ui *UI, item engine.ScheduleItem) float64 {
+	seq := ui.engine.Sequence()
+	if item.Frames == 0 && len(seq.Durations) > 0 {
+		var cycle time.Duration
+		for _, d := range seq.Durations {
+			cycle += d
+		}
+		return float64(seq.Len()) / cycle.Seconds()
+	}
+	flips := item.FlickeringRate.FlipsPerSecond()
+	if item.Sweep != engine.NoSweep {
+		flips = max(flips, item.EndRate.FlipsPerSecond())
+	}
+	return flips
+}
+
+func frameSources(frames []IMG) []image.Image {
+	srcs := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		srcs[i] = frame.src
+	}
+	return srcs
+}
+
+func (d *SafetyDialog) Layout(gtx layout.Context, th *material.Theme) layout.Dimensions {
+	if !d.isOpen {
+		return layout.Dimensions{}
+	}
+
+	// Keep clicks from reaching the controls below
+	area := clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops)
+	event.Op(gtx.Ops, d)
+	for {
+		if _, ok := gtx.Event(pointer.Filter{Target: d, Kinds: pointer.Press | pointer.Release}); !ok {
+			break
+		}
+	}
+	area.Pop()
+
+	gtx.Constraints.Min = image.Point{X: gtx.Dp(300), Y: gtx.Dp(300)}
+
+	return layout.Stack{}.Layout(gtx,
+		layout.Expanded(d.layoutBackground),
+		layout.Stacked(func(gtx layout.Context) layout.Dimensions {
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Max.X = min(gtx.Constraints.Max.X, gtx.Dp(560))
+				return layout.Stack{}.Layout(gtx,
+					layout.Expanded(d.layoutPopupBackground),
+					layout.Stacked(d.layoutContent(th)),
+				)
+			})
+		}),
+	)
+}
+
+func (d *SafetyDialog) layoutBackground(gtx layout.Context) layout.Dimensions {
+	paint.Fill(gtx.Ops, color.NRGBA{A: 200})
+	return layout.Dimensions{Size: gtx.Constraints.Min}
+}
+
+func (d *SafetyDialog) layoutPopupBackground(gtx layout.Context) layout.Dimensions {
+	r := gtx.Dp(8)
+	paint.FillShape(gtx.Ops,
+		color.NRGBA{R: 255, G: 255, B: 255, A: 255},
+		clip.RRect{Rect: image.Rectangle{Max: gtx.Constraints.Min}, NE: r, NW: r, SE: r, SW: r}.Op(gtx.Ops))
+	return layout.Dimensions{Size: gtx.Constraints.Min}
+}
+
+func (d *SafetyDialog) layoutContent(th *material.Theme) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(unit.Dp(16)).Layout(gtx,
+			func(gtx layout.Context) layout.Dimensions {
+				children := []layout.FlexChild{
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						title := material.H6(th, "Photosensitive seizure risk")
+						title.Alignment = text.Middle
+						return title.Layout(gtx)
+					}),
+					space(16),
+					layout.Rigid(material.Body1(th, "This session exceeds the general flash and red flash thresholds for photosensitive epilepsy:").Layout),
+					space(8),
+				}
+				for _, f := range d.report.Findings {
+					label := material.Body2(th, "• "+f.String())
+					label.Color = errorColor
+					children = append(children, layout.Rigid(label.Layout), space(4))
+				}
+				children = append(children,
+					layout.Rigid(material.Body2(th, "Session: "+d.report.Total()).Layout),
+					space(8),
+					layout.Rigid(material.Body1(th, "Only run it if the participant has been screened and agreed to it. The override is logged.").Layout),
+					space(16),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Flex{Spacing: layout.SpaceBetween}.Layout(gtx,
+							layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+								gtx.Constraints.Min.X = gtx.Constraints.Max.X
+								return material.Button(th, &d.cancelButton, "Cancel").Layout(gtx)
+							}),
+							layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+							layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+								gtx.Constraints.Min.X = gtx.Constraints.Max.X
+								btn := material.Button(th, &d.overrideButton, "Start Anyway")
+								btn.Background = errorColor
+								return btn.Layout(gtx)
+							}),
+						)
+					}),
+				)
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+			},
+		)
+	}
+}
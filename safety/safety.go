@@ -0,0 +1,284 @@
+// Package safety checks a flicker session against the photosensitive
+// seizure thresholds of the general flash and red flash rules, as used by
+// ITU-R BT.1702 for broadcasting and WCAG 2 for the web.
+//
+// A flash is a pair of opposing changes in relative luminance of at least
+// 10% of the maximum, while the darker state is below 0.8. A red flash is
+// a pair of opposing changes to or from saturated red. Either is hazardous
+// when it covers at least a quarter of the stimulus area and there are
+// more than three flashes in a second.
+//
+// The analysis works on the frames as they are stored, so it can't know
+// the brightness of the display or how much of the visual field the window
+// covers. It errs on the side of reporting.
+package safety
+
+import (
+	"fmt"
+	"gio_flicker/stimulus"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"time"
+)
+
+// Thresholds are the limits a session is checked against.
+type Thresholds struct {
+	LuminanceChange  float64       // relative luminance change of a flash
+	DarkLuminance    float64       // changes count while the darker state is below this
+	RedSaturation    float64       // share of red, R/(R+G+B), of a saturated red
+	RedChange        float64       // change of (R-G-B)*320 of a red flash
+	Area             float64       // share of the stimulus area that flashes
+	FlashesPerSecond float64       // most flashes in a second
+	SequenceLength   time.Duration // longest hazardous flashing without a break
+}
+
+// Default are the general flash and red flash thresholds.
+var Default = Thresholds{
+	LuminanceChange:  0.1,
+	DarkLuminance:    0.8,
+	RedSaturation:    0.8,
+	RedChange:        20,
+	Area:             0.25,
+	FlashesPerSecond: 3,
+	SequenceLength:   5 * time.Second,
+}
+
+// maxSamples limits the pixels compared per frame, large frames are
+// sampled on a grid.
+const maxSamples = 250000
+
+// Transition measures the change from one frame to the next.
+type Transition struct {
+	LuminanceChange float64 // mean change of relative luminance
+	Area            float64 // share of the area with a flash change
+	RedArea         float64 // share of the area with a red flash change
+}
+
+// Compare measures the transition between frames a and b of the same
+// size.
+func Compare(a, b image.Image, t Thresholds) Transition {
+	ab, bb := a.Bounds(), b.Bounds()
+	w, h := min(ab.Dx(), bb.Dx()), min(ab.Dy(), bb.Dy())
+	if w <= 0 || h <= 0 {
+		return Transition{}
+	}
+	stride := max(1, int(math.Ceil(math.Sqrt(float64(w*h)/maxSamples))))
+	var n, flash, red int
+	var change float64
+	for y := 0; y < h; y += stride {
+		for x := 0; x < w; x += stride {
+			ca := color.NRGBAModel.Convert(a.At(ab.Min.X+x, ab.Min.Y+y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(bb.Min.X+x, bb.Min.Y+y)).(color.NRGBA)
+			la, lb := stimulus.Luminance(ca), stimulus.Luminance(cb)
+			d := math.Abs(la - lb)
+			change += d
+			if d >= t.LuminanceChange && min(la, lb) < t.DarkLuminance {
+				flash++
+			}
+			ra, sa := redness(ca, t)
+			rb, sb := redness(cb, t)
+			if (sa || sb) && math.Abs(ra-rb) > t.RedChange {
+				red++
+			}
+			n++
+		}
+	}
+	return Transition{
+		LuminanceChange: change / float64(n),
+		Area:            float64(flash) / float64(n),
+		RedArea:         float64(red) / float64(n),
+	}
+}
+
+// redness returns the red value (R-G-B)*320 of c, zero when negative, and
+// whether c is a saturated red.
+func redness(c color.NRGBA, t Thresholds) (float64, bool) {
+	r, g, b := stimulus.Linear(c)
+	sum := r + g + b
+	return max(0, (r-g-b)*320), sum > 0 && r/sum >= t.RedSaturation
+}
+
+// Segment is a part of a session showing the same frames at the same
+// rate, such as a schedule step.
+type Segment struct {
+	Name           string        // for the report, e.g. "step 3"
+	Frames         []image.Image // shown in turn, the last followed by the first
+	FlipsPerSecond float64       // frame changes per second, the highest of a sweep
+	Duration       time.Duration // zero for no end
+}
+
+// Session is a list of segments and how often they are played.
+type Session struct {
+	Segments []Segment
+	Plays    int // zero to play them over and over
+}
+
+// Rule names the threshold a finding exceeds.
+type Rule int
+
+const (
+	GeneralFlash Rule = iota
+	RedFlash
+	LongSequence
+)
+
+var ruleNames = [...]string{"general flash", "red flash", "long sequence"}
+
+func (r Rule) String() string {
+	if r < 0 || int(r) >= len(ruleNames) {
+		return fmt.Sprintf("Rule(%d)", int(r))
+	}
+	return ruleNames[r]
+}
+
+// Finding is a threshold exceeded by a session.
+type Finding struct {
+	Segment string // empty for the whole session
+	Rule    Rule
+	Message string
+}
+
+func (f Finding) String() string {
+	if f.Segment == "" {
+		return fmt.Sprintf("%s: %s", f.Rule, f.Message)
+	}
+	return fmt.Sprintf("%s, %s: %s", f.Segment, f.Rule, f.Message)
+}
+
+// Report is the result of the analysis.
+type Report struct {
+	Findings  []Finding
+	Hazardous time.Duration // total time of hazardous flashing, -1 for no end
+}
+
+// Safe reports whether the session stays within the thresholds.
+func (r Report) Safe() bool {
+	return len(r.Findings) == 0
+}
+
+// Total describes the total time of hazardous flashing in the session.
+func (r Report) Total() string {
+	switch {
+	case r.Hazardous < 0:
+		return "hazardous flashing without an end"
+	case r.Hazardous == 0:
+		return "no hazardous flashing"
+	}
+	return fmt.Sprintf("hazardous flashing for %s in total", r.Hazardous)
+}
+
+// String lists the findings, one per line, and the total.
+func (r Report) String() string {
+	lines := make([]string, len(r.Findings), len(r.Findings)+1)
+	for i, f := range r.Findings {
+		lines[i] = f.String()
+	}
+	return strings.Join(append(lines, r.Total()), "\n")
+}
+
+// Analyze checks the frames, flash frequency and length of every segment
+// of the session against t.
+func Analyze(s Session, t Thresholds) Report {
+	var r Report
+	hazardous := make([]bool, len(s.Segments))
+	for i, seg := range s.Segments {
+		n := len(seg.Frames)
+		if n < 2 || seg.FlipsPerSecond <= 0 {
+			continue
+		}
+		// Every transition is one change, two make a flash
+		var flashes, reds int
+		var worst Transition
+		for k := range n {
+			if n == 2 && k == 1 {
+				// Going back is the same change
+				flashes, reds = flashes*2, reds*2
+				break
+			}
+			tr := Compare(seg.Frames[k], seg.Frames[(k+1)%n], t)
+			if tr.Area >= t.Area {
+				flashes++
+			}
+			if tr.RedArea >= t.Area {
+				reds++
+			}
+			worst.LuminanceChange = max(worst.LuminanceChange, tr.LuminanceChange)
+			worst.Area = max(worst.Area, tr.Area)
+			worst.RedArea = max(worst.RedArea, tr.RedArea)
+		}
+		perSecond := func(changes int) float64 {
+			return float64(changes) / float64(n) * seg.FlipsPerSecond / 2
+		}
+		if rate := perSecond(flashes); rate > t.FlashesPerSecond {
+			hazardous[i] = true
+			r.Findings = append(r.Findings, Finding{seg.Name, GeneralFlash, fmt.Sprintf(
+				"%.3g flashes/s over %.0f%% of the area with a mean luminance change of %.2f, the limit is %g flashes/s over %.0f%%",
+				rate, worst.Area*100, worst.LuminanceChange, t.FlashesPerSecond, t.Area*100)})
+		}
+		if rate := perSecond(reds); rate > t.FlashesPerSecond {
+			hazardous[i] = true
+			r.Findings = append(r.Findings, Finding{seg.Name, RedFlash, fmt.Sprintf(
+				"%.3g red flashes/s over %.0f%% of the area, the limit is %g flashes/s over %.0f%%",
+				rate, worst.RedArea*100, t.FlashesPerSecond, t.Area*100)})
+		}
+	}
+
+	longest, total := hazardousRuns(s, hazardous)
+	r.Hazardous = total
+	switch {
+	case longest < 0:
+		r.Findings = append(r.Findings, Finding{"", LongSequence, fmt.Sprintf(
+			"hazardous flashing without an end, the limit is %s", t.SequenceLength)})
+	case longest > t.SequenceLength:
+		r.Findings = append(r.Findings, Finding{"", LongSequence, fmt.Sprintf(
+			"hazardous flashing for %s without a break, the limit is %s", longest, t.SequenceLength)})
+	}
+	return r
+}
+
+// hazardousRuns returns the longest run of hazardous segments and their
+// total time in the session, -1 for no end.
+func hazardousRuns(s Session, hazardous []bool) (longest, total time.Duration) {
+	var run, once time.Duration
+	found, all := false, true
+	for i, seg := range s.Segments {
+		if !hazardous[i] {
+			run, all = 0, false
+			continue
+		}
+		if seg.Duration == 0 {
+			return -1, -1
+		}
+		found = true
+		run += seg.Duration
+		once += seg.Duration
+		longest = max(longest, run)
+	}
+	if !found {
+		return 0, 0
+	}
+	if s.Plays != 1 {
+		if all {
+			if s.Plays == 0 {
+				return -1, -1
+			}
+			return once * time.Duration(s.Plays), once * time.Duration(s.Plays)
+		}
+		// The run at the end goes on into the run at the start of the
+		// next play
+		var head time.Duration
+		for i, seg := range s.Segments {
+			if !hazardous[i] {
+				break
+			}
+			head += seg.Duration
+		}
+		longest = max(longest, run+head)
+	}
+	if s.Plays == 0 {
+		return longest, -1
+	}
+	return longest, once * time.Duration(s.Plays)
+}
@@ -0,0 +1,112 @@
+package safety
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	black   = color.NRGBA{A: 255}
+	white   = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	gray    = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	red     = color.NRGBA{R: 255, A: 255}
+	darkRed = color.NRGBA{R: 224, A: 255} // a red change too small for a general flash
+)
+
+// frame returns a 100x100 frame of bg with the first columns in fg.
+func frame(bg, fg color.NRGBA, columns int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, columns, 100), image.NewUniform(fg), image.Point{}, draw.Src)
+	return img
+}
+
+func solid(c color.NRGBA) image.Image {
+	return frame(c, c, 0)
+}
+
+func rules(r Report) []Rule {
+	var rules []Rule
+	for _, f := range r.Findings {
+		rules = append(rules, f.Rule)
+	}
+	return rules
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []image.Image
+		flips  float64 // frame changes per second
+		want   []Rule
+	}{
+		// Two flips make a flash, so 6 flips/s are 3 flashes/s
+		{"black/white at 3 flips/s", []image.Image{solid(black), solid(white)}, 3, nil},
+		{"black/white at 4 flips/s", []image.Image{solid(black), solid(white)}, 4, nil},
+		{"black/white at 6 flips/s", []image.Image{solid(black), solid(white)}, 6, nil},
+		{"black/white at 7 flips/s", []image.Image{solid(black), solid(white)}, 7, []Rule{GeneralFlash}},
+		{"gray/white at 20 flips/s", []image.Image{solid(gray), solid(white)}, 20, []Rule{GeneralFlash}},
+		{"steady", []image.Image{solid(gray), solid(gray)}, 20, nil},
+		{"red flash", []image.Image{solid(red), solid(darkRed)}, 8, []Rule{RedFlash}},
+		{"red flash at 6 flips/s", []image.Image{solid(red), solid(darkRed)}, 6, nil},
+		{"red and black", []image.Image{solid(red), solid(black)}, 8, []Rule{GeneralFlash, RedFlash}},
+		{"25% of the area", []image.Image{frame(gray, black, 25), frame(gray, white, 25)}, 20, []Rule{GeneralFlash}},
+		{"24% of the area", []image.Image{frame(gray, black, 24), frame(gray, white, 24)}, 20, nil},
+		// Four frames with two flashes per pass
+		{"sequence", []image.Image{solid(black), solid(white), solid(black), solid(white)}, 7, []Rule{GeneralFlash}},
+		{"sequence with steady frames", []image.Image{solid(black), solid(black), solid(white), solid(white)}, 7, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Session{
+				Segments: []Segment{{Name: "step 1", Frames: tt.frames, FlipsPerSecond: tt.flips, Duration: 2 * time.Second}},
+				Plays:    1,
+			}
+			if got := rules(Analyze(s, Default)); !slices.Equal(got, tt.want) {
+				t.Errorf("findings %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSequenceLength(t *testing.T) {
+	flashing := []image.Image{solid(black), solid(white)}
+	steady := []image.Image{solid(gray), solid(gray)}
+	seg := func(frames []image.Image, d time.Duration) Segment {
+		return Segment{Frames: frames, FlipsPerSecond: 20, Duration: d}
+	}
+	tests := []struct {
+		name     string
+		segments []Segment
+		plays    int
+		long     bool          // a LongSequence finding is expected
+		total    time.Duration // hazardous flashing in the session
+	}{
+		{"5s", []Segment{seg(flashing, 5*time.Second)}, 1, false, 5 * time.Second},
+		{"6s", []Segment{seg(flashing, 6*time.Second)}, 1, true, 6 * time.Second},
+		{"3s + 3s in a row", []Segment{seg(flashing, 3*time.Second), seg(flashing, 3*time.Second)}, 1, true, 6 * time.Second},
+		{"3s and 3s apart", []Segment{seg(flashing, 3*time.Second), seg(steady, 10*time.Second), seg(flashing, 3*time.Second)}, 1, false, 6 * time.Second},
+		{"played twice", []Segment{seg(flashing, 3*time.Second), seg(steady, 10*time.Second)}, 2, false, 6 * time.Second},
+		// The last step runs into the first when the schedule starts over
+		{"wraparound played again", []Segment{seg(flashing, 3*time.Second), seg(steady, 10*time.Second), seg(flashing, 3*time.Second)}, 2, true, 12 * time.Second},
+		{"wraparound forever", []Segment{seg(flashing, 3*time.Second), seg(steady, 10*time.Second), seg(flashing, 3*time.Second)}, 0, true, -1},
+		{"short and forever", []Segment{seg(flashing, 2*time.Second), seg(steady, 10*time.Second)}, 0, false, -1},
+		{"only flashing forever", []Segment{seg(flashing, time.Second)}, 0, true, -1},
+		{"no end", []Segment{seg(flashing, 0)}, 1, true, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Analyze(Session{Segments: tt.segments, Plays: tt.plays}, Default)
+			if got := slices.Contains(rules(r), LongSequence); got != tt.long {
+				t.Errorf("long sequence %v, want %v: %v", got, tt.long, r)
+			}
+			if r.Hazardous != tt.total {
+				t.Errorf("hazardous %v, want %v", r.Hazardous, tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Package schedule parses and formats the flicker schedule language.
+//
+// A schedule is a list of steps separated by semicolons or newlines.
+// Everything from '#' to the end of a line is a comment. Every step starts
+// with its duration followed by the step type:
+//
+//	16s @ 12Hz                    # flicker at 12 Hz for 16 seconds
+//	16s flicker 24flips/s         # the same step with the named type
+//	500ms blank                   # blank screen
+//	30s sweep 5Hz..40Hz log       # logarithmic sweep, "linear" is the default
+//	2min @ 40Hz duty 25% wave sine
+//	5min @ 12Hz show images "faces/a.png" "faces/b.png"
+//	5min @ 12Hz show pattern "checkerboard 1deg"
+//	1min @ 1Hz show color "#808080"   # steady grey field
+//	repeat 8 { 16s @ 12Hz; 16s blank }   # blocks may be nested
+//	on end stop                   # what happens after the last step
+//
+// The grammar in EBNF:
+//
+//	schedule = { separator } [ step { separator { separator } step } ] { separator } .
+//	step     = duration ( flicker | blank | sweep ) | repeat | onEnd .
+//	repeat   = "repeat" integer "{" schedule "}" .
+//	onEnd    = "on" "end" ( "stop" | "loop" [ integer ] | "blank" ) .
+//	flicker  = ( "@" | "flicker" ) rate { option } .
+//	blank    = "blank" .
+//	sweep    = "sweep" rate ".." rate [ "linear" | "log" ] { option } .
+//	option   = "duty" number "%" | "wave" ( "square" | "sine" | "triangle" | "sawtooth" ) | "show" stimulus .
+//	stimulus = ( "images" | "pattern" | "color" ) string { string } .
+//	duration = number ( "ms" | "s" | "min" ) .
+//	rate     = number ( "Hz" | "flips/s" ) .
+//
+// Repeat blocks are expanded into copies of their steps when parsing.
+//
+// The "on end" line may appear once, outside repeat blocks. "stop" ends
+// the session after the last step, "loop N" plays the schedule N times and
+// then stops, "blank" ends the session but keeps a blank screen until the
+// user stops it, and "loop" without a count starts over forever, which is
+// also the default.
+// A step with "show" flickers its own stimulus instead of the images or
+// pattern chosen in the app: image files, a pattern spec of the stimulus
+// package or colors written as "#rrggbb", shown in turn at the rate of the
+// step. A single image or color is shown steadily. Relative image paths
+// are resolved from the folder of the schedule. Strings are quoted as in
+// Go.
+//
+// Hz counts full on/off cycles and flips/s counts phase reversals. Units
+// are required so a schedule means the same thing everywhere. Keywords and
+// units are case insensitive.
+//
+// Schedules in the older "33-3;4;44-3" form are still accepted so existing
+// schedule files keep working: whole seconds of blank screen, or whole
+// seconds and a rate without a unit, which uses the default unit passed to
+// Parse. Text with anything else is read as the schedule language.
+//
+// To share a protocol, a schedule can also be stored as a JSON or YAML
+// Document carrying metadata such as name, author and citation. Its steps
+// use the same notation for durations and rates; Schema describes the
+// format.
+package schedule
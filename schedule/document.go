@@ -0,0 +1,393 @@
+package schedule
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gio_flicker/engine"
+	"gio_flicker/stimulus"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Schema is the JSON Schema of schedule documents. YAML documents follow
+// the same structure.
+//
+//go:embed schema.json
+var Schema string
+
+// Document is a schedule together with the metadata needed to share it as
+// a protocol. It is stored as JSON or YAML.
+type Document struct {
+	Schema      string   `json:"$schema,omitempty" yaml:"$schema,omitempty"`
+	Name        string   `json:"name" yaml:"name"`
+	Author      string   `json:"author,omitempty" yaml:"author,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Version     string   `json:"version,omitempty" yaml:"version,omitempty"`
+	Citation    string   `json:"citation,omitempty" yaml:"citation,omitempty"`
+	RefreshHz   float64  `json:"refresh_hz,omitempty" yaml:"refresh_hz,omitempty"` // display refresh the protocol was made for
+	Images      []string `json:"images,omitempty" yaml:"images,omitempty"`         // stimulus image files, relative to the document
+	OnEnd       string   `json:"on_end,omitempty" yaml:"on_end,omitempty"`         // "stop", "loop", "loop N" or "blank"
+	Steps       []Step   `json:"steps" yaml:"steps"`
+}
+
+// Step is a step or repeat block of a Document. Durations and rates are
+// written as in the schedule language, e.g. "16s" and "12Hz".
+type Step struct {
+	Duration string  `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Type     string  `json:"type,omitempty" yaml:"type,omitempty"` // "flicker", "blank" or "sweep", flicker if empty
+	Rate     string  `json:"rate,omitempty" yaml:"rate,omitempty"`
+	EndRate  string  `json:"end_rate,omitempty" yaml:"end_rate,omitempty"`
+	Sweep    string  `json:"sweep,omitempty" yaml:"sweep,omitempty"` // "linear" or "log"
+	Duty     float64 `json:"duty,omitempty" yaml:"duty,omitempty"`   // percentage of each cycle showing the first image
+	Wave     string  `json:"wave,omitempty" yaml:"wave,omitempty"`
+
+	// Show is the stimulus of the step, nil for the session stimulus
+	Show *stimulus.Source `json:"show,omitempty" yaml:"show,omitempty"`
+
+	// A repeat block has only these two fields
+	Repeat int    `json:"repeat,omitempty" yaml:"repeat,omitempty"`
+	Steps  []Step `json:"steps,omitempty" yaml:"steps,omitempty"`
+}
+
+// Encoding is the file format of a Document.
+type Encoding int
+
+const (
+	JSON Encoding = iota
+	YAML
+)
+
+// EncodingOf picks the encoding from the extension of path. It reports
+// false for files that are not documents, such as plain schedule text.
+func EncodingOf(path string) (Encoding, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON, true
+	case ".yaml", ".yml":
+		return YAML, true
+	}
+	return 0, false
+}
+
+// DecodeDocument reads a document and checks it. Unknown fields are errors
+// so misspelt keys do not silently change a protocol.
+func DecodeDocument(data []byte, enc Encoding) (*Document, error) {
+	d := new(Document)
+	var err error
+	if enc == YAML {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(d)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(d)
+	}
+	if err == io.EOF {
+		return nil, errors.New("the document is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := d.nodes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Encode writes the document.
+func (d *Document) Encode(enc Encoding) ([]byte, error) {
+	if enc == YAML {
+		var b bytes.Buffer
+		e := yaml.NewEncoder(&b)
+		e.SetIndent(2)
+		if err := e.Encode(d); err != nil {
+			return nil, err
+		}
+		err := e.Close()
+		return b.Bytes(), err
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	return append(data, '\n'), err
+}
+
+// ImagePaths returns the session images of the document with relative
+// paths resolved from dir, the folder of the document.
+func (d *Document) ImagePaths(dir string) []string {
+	if len(d.Images) == 0 {
+		return nil
+	}
+	paths := make([]string, len(d.Images))
+	for i, path := range d.Images {
+		paths[i] = ResolvePath(dir, path)
+	}
+	return paths
+}
+
+// CheckFiles reports every image file of the document that can't be found
+// from dir, the session images and those shown by steps, with the path of
+// its field, e.g. "steps[1].show.images[0]".
+func (d *Document) CheckFiles(dir string) error {
+	var errs []error
+	check := func(field string, paths []string) {
+		for i, path := range paths {
+			if _, err := os.Stat(ResolvePath(dir, path)); err != nil {
+				errs = append(errs, fmt.Errorf("%s[%d]: %w", field, i, err))
+			}
+		}
+	}
+	check("images", d.Images)
+	var walk func(steps []Step, path string)
+	walk = func(steps []Step, path string) {
+		for i, s := range steps {
+			field := fmt.Sprintf("%s[%d]", path, i)
+			if s.Show != nil {
+				check(field+".show.images", s.Show.Images)
+			}
+			walk(s.Steps, field+".steps")
+		}
+	}
+	walk(d.Steps, "steps")
+	return errors.Join(errs...)
+}
+
+// ResolvePath returns path, joined to dir if it is relative. Image files
+// of schedules are resolved from the folder of the schedule this way.
+func ResolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Schedule returns the steps of the document with repeat blocks expanded.
+func (d *Document) Schedule() (engine.Schedule, error) {
+	nodes, onEnd, err := d.nodes()
+	if err != nil {
+		return engine.Schedule{}, err
+	}
+	return engine.Schedule{Items: expand(nodes, nil), OnEnd: onEnd}, nil
+}
+
+// Text writes the steps of the document in the schedule language, keeping
+// repeat blocks.
+func (d *Document) Text() (string, error) {
+	nodes, onEnd, err := d.nodes()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if onEnd.Action != engine.LoopForever {
+		b.WriteString("on end " + onEnd.String() + "\n")
+	}
+	formatNodes(&b, nodes, "")
+	return b.String(), nil
+}
+
+// SetText replaces the steps and end policy of the document with the
+// schedule in text. The metadata is kept. Rates in the old syntax without a
+// unit use defaultUnit.
+func (d *Document) SetText(text string, defaultUnit engine.RateUnit) error {
+	var nodes []node
+	var onEnd engine.EndPolicy
+	if IsLegacy(text) {
+		items, err := parseLegacy(text, defaultUnit)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			nodes = append(nodes, node{item: item})
+		}
+	} else {
+		var err error
+		if nodes, onEnd, err = parse(text); err != nil {
+			return err
+		}
+	}
+	d.Steps = nodeSteps(nodes)
+	d.OnEnd = ""
+	if onEnd.Action != engine.LoopForever {
+		d.OnEnd = onEnd.String()
+	}
+	return nil
+}
+
+// nodes checks the document and converts its steps. Every problem is
+// reported with the path of the field, e.g. "steps[2].rate".
+func (d *Document) nodes() ([]node, engine.EndPolicy, error) {
+	var errs []error
+	var onEnd engine.EndPolicy
+	if d.OnEnd != "" {
+		policy, err := parseField(d.OnEnd, (*parser).parseEndPolicy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("on_end: %w", err))
+		}
+		onEnd = policy
+	}
+	if len(d.Steps) == 0 {
+		errs = append(errs, errors.New("steps: the schedule has no steps"))
+	}
+	nodes := stepNodes(d.Steps, "steps", &errs)
+	if len(errs) == 0 && size(nodes) > maxSteps {
+		errs = append(errs, fmt.Errorf("steps: the schedule expands to more than %d steps", maxSteps))
+	}
+	return nodes, onEnd, errors.Join(errs...)
+}
+
+func stepNodes(steps []Step, path string, errs *[]error) []node {
+	nodes := make([]node, 0, len(steps))
+	for i, s := range steps {
+		nodes = append(nodes, stepNode(s, fmt.Sprintf("%s[%d]", path, i), errs))
+	}
+	return nodes
+}
+
+func stepNode(s Step, path string, errs *[]error) node {
+	fail := func(field string, err error) {
+		*errs = append(*errs, fmt.Errorf("%s.%s: %w", path, field, err))
+	}
+	unexpected := func(field, value, why string) {
+		if value != "" {
+			fail(field, errors.New(why))
+		}
+	}
+	if s.Repeat != 0 || s.Steps != nil {
+		if s.Repeat < 1 {
+			fail("repeat", fmt.Errorf("repeat count must be at least 1, found %d", s.Repeat))
+		}
+		if len(s.Steps) == 0 {
+			fail("steps", errors.New("repeat block has no steps"))
+		}
+		if s.Duration != "" || s.Type != "" || s.Rate != "" || s.EndRate != "" ||
+			s.Sweep != "" || s.Duty != 0 || s.Wave != "" || s.Show != nil {
+			*errs = append(*errs, fmt.Errorf("%s: a repeat block only has repeat and steps", path))
+		}
+		body := stepNodes(s.Steps, path+".steps", errs)
+		if s.Repeat > maxSteps/max(1, size(body)) {
+			fail("repeat", fmt.Errorf("repeat block expands to more than %d steps", maxSteps))
+		}
+		return node{count: s.Repeat, body: body}
+	}
+
+	var item engine.ScheduleItem
+	var err error
+	if item.Duration, err = parseField(s.Duration, (*parser).parseDuration); err != nil {
+		fail("duration", err)
+	}
+	switch strings.ToLower(s.Type) {
+	case "", "flicker":
+		unexpected("end_rate", s.EndRate, "only sweeps have an end rate")
+		unexpected("sweep", s.Sweep, "only sweeps have a sweep shape")
+	case "blank":
+		item.Blank = true
+		unexpected("rate", s.Rate, "blank steps have no rate")
+		unexpected("end_rate", s.EndRate, "blank steps have no rate")
+		unexpected("sweep", s.Sweep, "blank steps have no rate")
+		unexpected("wave", s.Wave, "blank steps have no waveform")
+		if s.Duty != 0 {
+			fail("duty", errors.New("blank steps have no duty cycle"))
+		}
+		if s.Show != nil {
+			fail("show", errors.New("blank steps show no stimulus"))
+		}
+		return node{item: item}
+	case "sweep":
+		item.Sweep = engine.LinearSweep
+		if s.Sweep != "" {
+			if item.Sweep, err = engine.ParseSweep(s.Sweep); err != nil || item.Sweep == engine.NoSweep {
+				fail("sweep", fmt.Errorf("unknown sweep %q, expected linear or log", s.Sweep))
+			}
+		}
+		if item.EndRate, err = parseField(s.EndRate, (*parser).parseRate); err != nil {
+			fail("end_rate", err)
+		}
+	default:
+		fail("type", fmt.Errorf("unknown step type %q, expected flicker, blank or sweep", s.Type))
+	}
+	if item.FlickeringRate, err = parseField(s.Rate, (*parser).parseRate); err != nil {
+		fail("rate", err)
+	}
+	if s.Duty != 0 {
+		if err := engine.ValidateDutyCycle(s.Duty / 100); err != nil {
+			fail("duty", err)
+		}
+		item.DutyCycle = s.Duty / 100
+	}
+	if s.Wave != "" {
+		if item.Waveform, err = engine.ParseWaveform(s.Wave); err != nil {
+			fail("wave", fmt.Errorf("unknown waveform %q, expected square, sine, triangle or sawtooth", s.Wave))
+		}
+	}
+	if s.Show != nil {
+		if err := s.Show.Validate(); err != nil {
+			fail("show", err)
+		} else {
+			setSource(&item, *s.Show)
+		}
+	}
+	return node{item: item}
+}
+
+// parseField parses a single value of a document with the parser of the
+// schedule language, so both accept the same notation.
+func parseField[T any](text string, parse func(*parser) T) (v T, err error) {
+	if strings.TrimSpace(text) == "" {
+		return v, errors.New("missing value")
+	}
+	p := &parser{tokens: lex(text)}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isBailout := r.(bailout); !isBailout {
+				panic(r)
+			}
+			err = errors.New(p.errs[0].Msg)
+		}
+	}()
+	v = parse(p)
+	if t := p.peek(); t.kind != tokEOF {
+		p.fail(t, "unexpected %s", t)
+	}
+	return v, nil
+}
+
+func nodeSteps(nodes []node) []Step {
+	steps := make([]Step, 0, len(nodes))
+	for _, nd := range nodes {
+		if nd.count > 0 {
+			steps = append(steps, Step{Repeat: nd.count, Steps: nodeSteps(nd.body)})
+		} else {
+			steps = append(steps, itemStep(nd.item))
+		}
+	}
+	return steps
+}
+
+func itemStep(item engine.ScheduleItem) Step {
+	s := Step{Duration: FormatDuration(item.Duration)}
+	switch {
+	case item.Blank:
+		s.Type = "blank"
+		return s
+	case item.Sweep != engine.NoSweep:
+		s.Type = "sweep"
+		s.Sweep = item.Sweep.String()
+		s.EndRate = FormatRate(item.EndRate)
+	default:
+		s.Type = "flicker"
+	}
+	s.Rate = FormatRate(item.FlickeringRate)
+	s.Duty = item.DutyCycle * 100
+	if item.Waveform != engine.DefaultWaveform {
+		s.Wave = item.Waveform.String()
+	}
+	if src, err := stimulus.ParseSource(item.Stimulus); err == nil {
+		s.Show = &src
+	}
+	return s
+}
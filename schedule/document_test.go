@@ -0,0 +1,249 @@
+package schedule
+
+import (
+	"gio_flicker/engine"
+	"gio_flicker/stimulus"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const protocolJSON = `{
+  "name": "CSF on/off",
+  "author": "Jane Doe",
+  "refresh_hz": 60,
+  "images": ["img1.png", "img2.png"],
+  "on_end": "stop",
+  "steps": [
+    {"repeat": 8, "steps": [
+      {"duration": "16s", "rate": "12Hz"},
+      {"duration": "16s", "type": "blank"}
+    ]},
+    {"duration": "30s", "type": "sweep", "rate": "5Hz", "end_rate": "40Hz", "sweep": "log", "duty": 25, "wave": "sine"}
+  ]
+}`
+
+const protocolYAML = `name: CSF on/off
+author: Jane Doe
+refresh_hz: 60
+images: [img1.png, img2.png]
+on_end: stop
+steps:
+  - repeat: 8
+    steps:
+      - duration: 16s
+        rate: 12Hz
+      - duration: 16s
+        type: blank
+  - duration: 30s
+    type: sweep
+    rate: 5Hz
+    end_rate: 40Hz
+    sweep: log
+    duty: 25
+    wave: sine
+`
+
+func TestDecodeDocument(t *testing.T) {
+	for _, tt := range []struct {
+		enc  Encoding
+		data string
+	}{
+		{JSON, protocolJSON},
+		{YAML, protocolYAML},
+	} {
+		d, err := DecodeDocument([]byte(tt.data), tt.enc)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.enc, err)
+		}
+		if d.Name != "CSF on/off" || d.RefreshHz != 60 || !reflect.DeepEqual(d.Images, []string{"img1.png", "img2.png"}) {
+			t.Errorf("%v: metadata %+v", tt.enc, d)
+		}
+		s, err := d.Schedule()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Items) != 17 || s.OnEnd.Action != engine.StopAtEnd {
+			t.Errorf("%v: %d steps ending with %v, want 17 and stop", tt.enc, len(s.Items), s.OnEnd)
+		}
+		sweep := s.Items[16]
+		if sweep.Sweep != engine.LogSweep || sweep.EndRate != engine.Hz(40) || sweep.DutyCycle != 0.25 || sweep.Waveform != engine.Sine {
+			t.Errorf("%v: sweep %+v", tt.enc, sweep)
+		}
+	}
+}
+
+func TestDocumentRoundTrip(t *testing.T) {
+	for _, enc := range []Encoding{JSON, YAML} {
+		d, err := DecodeDocument([]byte(protocolJSON), JSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := d.Encode(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := DecodeDocument(data, enc)
+		if err != nil {
+			t.Fatalf("%v: decoding %s: %v", enc, data, err)
+		}
+		if !reflect.DeepEqual(d, back) {
+			t.Errorf("%v: round trip changed the document:\n%+v\n%+v", enc, d, back)
+		}
+		text, err := d.Text()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := back.SetText(text, engine.Hertz); err != nil {
+			t.Fatal(err)
+		}
+		a, _ := d.Schedule()
+		b, _ := back.Schedule()
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%v: the text of the steps reads back as a different schedule", enc)
+		}
+	}
+}
+
+func TestDecodeDocumentErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{"name": "x", "steps": [{"duration": "1s", "rate": "12"}]}`, "steps[0].rate:"},
+		{`{"name": "x", "steps": [{"duration": "1s", "type": "blank", "rate": "12Hz"}]}`, "steps[0].rate: blank steps have no rate"},
+		{`{"name": "x", "stepz": []}`, "unknown field"},
+		{`{"name": "x", "steps": []}`, "steps: the schedule has no steps"},
+		// Nested counts whose product would overflow
+		{`{"steps":[{"repeat":4294967296,"steps":[{"repeat":4294967296,"steps":[{"duration":"1s","type":"blank"}]}]}]}`,
+			"steps[0].steps[0].repeat: repeat block expands to more than 100000 steps"},
+		{`{"steps":[{"repeat":100001,"steps":[{"duration":"1s","type":"blank"}]}]}`,
+			"steps[0].repeat: repeat block expands to more than 100000 steps"},
+		{`{"steps":[{"duration":"200000000000min","type":"blank"}]}`, "steps[0].duration: step duration is too long"},
+	}
+	for _, tt := range tests {
+		_, err := DecodeDocument([]byte(tt.data), JSON)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestEncodingOf(t *testing.T) {
+	tests := []struct {
+		path string
+		enc  Encoding
+		ok   bool
+	}{
+		{"protocol.json", JSON, true},
+		{"PROTOCOL.JSON", JSON, true},
+		{"dir.yaml/protocol.yaml", YAML, true},
+		{"protocol.yml", YAML, true},
+		{"schedule.txt", 0, false},
+		{"protocol.json.bak", 0, false},
+		{"protocol", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		enc, ok := EncodingOf(tt.path)
+		if enc != tt.enc || ok != tt.ok {
+			t.Errorf("EncodingOf(%q) = %v, %v, want %v, %v", tt.path, enc, ok, tt.enc, tt.ok)
+		}
+	}
+}
+
+func TestImagePaths(t *testing.T) {
+	dir := filepath.Join("home", "protocols")
+	abs := filepath.Join(t.TempDir(), "b.png")
+	d := &Document{Images: []string{"a.png", abs, filepath.Join("faces", "c.png"), filepath.Join("..", "d.png")}}
+	want := []string{
+		filepath.Join(dir, "a.png"),
+		abs,
+		filepath.Join(dir, "faces", "c.png"),
+		filepath.Join("home", "d.png"),
+	}
+	if got := d.ImagePaths(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("paths %q, want %q", got, want)
+	}
+	if got := (&Document{}).ImagePaths(dir); got != nil {
+		t.Errorf("paths %q without images", got)
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", filepath.Join("faces", "b.png")} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	abs := filepath.Join(dir, "a.png")
+	d := &Document{
+		Images: []string{"a.png", "gone.png"},
+		Steps: []Step{
+			{Repeat: 2, Steps: []Step{
+				{Duration: "1s", Rate: "10Hz", Show: &stimulus.Source{Images: []string{abs, "missing.png"}}},
+			}},
+			{Duration: "1s", Rate: "10Hz", Show: &stimulus.Source{Images: []string{"faces/b.png"}}},
+			{Duration: "1s", Rate: "10Hz", Show: &stimulus.Source{Pattern: "checkerboard 8px"}},
+		},
+	}
+	// Missing files are no error of the schedule itself
+	if _, err := d.Schedule(); err != nil {
+		t.Fatal(err)
+	}
+	err := d.CheckFiles(dir)
+	if err == nil {
+		t.Fatal("no error for missing files")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "images[1]: ") || !strings.Contains(lines[0], "gone.png") ||
+		!strings.HasPrefix(lines[1], "steps[0].steps[0].show.images[1]: ") || !strings.Contains(lines[1], "missing.png") {
+		t.Errorf("errors %q, want images[1] and steps[0].steps[0].show.images[1]", lines)
+	}
+	if err := d.CheckFiles(filepath.Join(dir, "faces")); err == nil || strings.Count(err.Error(), "\n") != 3 {
+		t.Errorf("from another folder: %v, want four missing files", err)
+	}
+
+	d.Images = d.Images[:1]
+	d.Steps[0].Steps[0].Show.Images = d.Steps[0].Steps[0].Show.Images[:1]
+	if err := d.CheckFiles(dir); err != nil {
+		t.Errorf("all files present: %v", err)
+	}
+}
+
+func TestShowRoundTrip(t *testing.T) {
+	d := &Document{Steps: []Step{
+		{Duration: "2s", Rate: "10Hz", Show: &stimulus.Source{Images: []string{"my face.png", `odd "name".png`, "missing/x.png"}}},
+		{Duration: "2s", Rate: "5Hz", Show: &stimulus.Source{Pattern: "checkerboard 1deg"}},
+		{Duration: "2s", Rate: "5Hz", Show: &stimulus.Source{Colors: []string{"#ff0000", "#00ff00"}}},
+		{Duration: "2s", Type: "blank"},
+	}}
+	text, err := d.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, `images "my face.png" "odd \"name\".png" "missing/x.png"`) {
+		t.Errorf("text %q does not quote the images", text)
+	}
+	back := &Document{}
+	if err := back.SetText(text, engine.Hertz); err != nil {
+		t.Fatalf("%q: %v", text, err)
+	}
+	for i := range d.Steps {
+		if !reflect.DeepEqual(back.Steps[i].Show, d.Steps[i].Show) {
+			t.Errorf("step %d shows %+v after the round trip, want %+v", i, back.Steps[i].Show, d.Steps[i].Show)
+		}
+	}
+	a, _ := d.Schedule()
+	b, _ := back.Schedule()
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("the round trip changed the schedule:\n%+v\n%+v", a, b)
+	}
+}
@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"gio_flicker/engine"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format writes a schedule in the schedule language, one step per line
+// with explicit units, so the text reads the same whatever default unit is
+// selected when it is parsed again.
+func Format(s engine.Schedule) string {
+	var b strings.Builder
+	if s.OnEnd.Action != engine.LoopForever {
+		b.WriteString("on end " + s.OnEnd.String() + "\n")
+	}
+	for _, item := range s.Items {
+		b.WriteString(FormatStep(item))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// formatNodes writes one step per line with the body of repeat blocks
+// indented.
+func formatNodes(b *strings.Builder, nodes []node, indent string) {
+	for _, nd := range nodes {
+		b.WriteString(indent)
+		if nd.count == 0 {
+			b.WriteString(FormatStep(nd.item) + "\n")
+			continue
+		}
+		b.WriteString("repeat " + strconv.Itoa(nd.count) + " {\n")
+		formatNodes(b, nd.body, indent+"  ")
+		b.WriteString(indent + "}\n")
+	}
+}
+
+// FormatStep writes a single step, e.g. "16s @ 12Hz duty 25%".
+func FormatStep(item engine.ScheduleItem) string {
+	parts := []string{FormatDuration(item.Duration)}
+	switch {
+	case item.Blank:
+		return parts[0] + " blank"
+	case item.Sweep != engine.NoSweep:
+		parts = append(parts, "sweep", FormatRate(item.FlickeringRate)+".."+FormatRate(item.EndRate))
+		if item.Sweep == engine.LogSweep {
+			parts = append(parts, "log")
+		}
+	default:
+		parts = append(parts, "@", FormatRate(item.FlickeringRate))
+	}
+	if item.DutyCycle != 0 {
+		parts = append(parts, "duty", formatNumber(item.DutyCycle*100)+"%")
+	}
+	if item.Waveform != engine.DefaultWaveform {
+		parts = append(parts, "wave", item.Waveform.String())
+	}
+	if item.Stimulus != "" {
+		parts = append(parts, "show", item.Stimulus)
+	}
+	return strings.Join(parts, " ")
+}
+
+// FormatRate writes a rate with its unit, e.g. "40Hz".
+func FormatRate(rate engine.Rate) string {
+	return formatNumber(rate.Value) + rate.Unit.String()
+}
+
+// FormatDuration writes d in the largest unit that keeps it whole.
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d%time.Minute == 0:
+		return formatNumber(float64(d/time.Minute)) + "min"
+	case d%time.Second == 0:
+		return formatNumber(float64(d/time.Second)) + "s"
+	}
+	return formatNumber(float64(d)/float64(time.Millisecond)) + "ms"
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
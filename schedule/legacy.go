@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"fmt"
+	"gio_flicker/engine"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacySegment matches one step of the old "33-3;4;44-3" syntax: whole
+// seconds of blank screen, or whole seconds and a whole rate.
+var legacySegment = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+// maxLegacySeconds is the longest step a time.Duration can hold.
+const maxLegacySeconds = math.MaxInt64 / int64(time.Second)
+
+// IsLegacy reports whether text is written in the old "33-3;4;44-3" syntax.
+// Anything else, including steps with units or options, is read as the
+// schedule language.
+func IsLegacy(text string) bool {
+	found := false
+	for _, part := range strings.Split(text, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !legacySegment.MatchString(part) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// parseLegacy reads the old syntax: seconds of blank screen, or seconds and
+// a rate in defaultUnit separated by "-".
+func parseLegacy(text string, defaultUnit engine.RateUnit) ([]engine.ScheduleItem, error) {
+	var items []engine.ScheduleItem
+	var errs ErrorList
+	col := 1
+	for _, raw := range strings.Split(text, ";") {
+		pos := Pos{Line: 1, Col: col + len(raw) - len(strings.TrimLeft(raw, " \t"))}
+		col += len(raw) + 1
+		part := strings.TrimSpace(raw)
+		if part == "" {
+			continue
+		}
+		item, err := parseLegacySegment(part, defaultUnit)
+		if err != nil {
+			errs = append(errs, &Error{Pos: pos, Msg: fmt.Sprintf("%q: %v", part, err)})
+			continue
+		}
+		items = append(items, item)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func parseLegacySegment(part string, defaultUnit engine.RateUnit) (engine.ScheduleItem, error) {
+	var item engine.ScheduleItem
+	durationText, rateText, flicker := strings.Cut(part, "-")
+	seconds, err := strconv.ParseInt(durationText, 10, 64)
+	if err != nil || seconds > maxLegacySeconds {
+		return item, fmt.Errorf("step duration is too long")
+	}
+	if seconds <= 0 {
+		return item, fmt.Errorf("step duration must be positive")
+	}
+	item.Duration = time.Duration(seconds) * time.Second
+	if !flicker {
+		// This is a blank screen time
+		item.Blank = true
+		return item, nil
+	}
+	value, err := strconv.ParseFloat(rateText, 64)
+	if err != nil {
+		return item, fmt.Errorf("bad rate %q", rateText)
+	}
+	item.FlickeringRate = engine.Rate{Value: value, Unit: defaultUnit}
+	if _, err := engine.FlipPeriod(item.FlickeringRate.FlipsPerSecond()); err != nil {
+		return item, fmt.Errorf("rate %s is not usable: %v", item.FlickeringRate.Short(), err)
+	}
+	return item, nil
+}
@@ -0,0 +1,88 @@
+package schedule
+
+import (
+	"errors"
+	"gio_flicker/engine"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLegacy(t *testing.T) {
+	s, err := Parse("33-3; 4 ;44-3;", engine.Hertz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []engine.ScheduleItem{
+		{Duration: 33 * time.Second, FlickeringRate: engine.Hz(3)},
+		{Duration: 4 * time.Second, Blank: true},
+		{Duration: 44 * time.Second, FlickeringRate: engine.Hz(3)},
+	}
+	if !slices.Equal(s.Items, want) {
+		t.Errorf("steps %+v, want %+v", s.Items, want)
+	}
+	if s, _ := Parse("10-7", engine.FlipsPerSecond); s.Items[0].FlickeringRate != engine.Flips(7) {
+		t.Errorf("rate %v, want the default unit flips/s", s.Items[0].FlickeringRate)
+	}
+}
+
+func TestIsLegacy(t *testing.T) {
+	tests := []struct {
+		text   string
+		legacy bool
+	}{
+		{"33-3;4;44-3", true},
+		{"10", true},
+		{" 10-40 ;\n", true},
+		{"", false},
+		{";", false},
+		// Units and options belong to the schedule language
+		{"30-40Hz", false},
+		{"30-40:25%", false},
+		{"30-40:sine", false},
+		{"60-5..40", false},
+		{"10s blank", false},
+	}
+	for _, tt := range tests {
+		if got := IsLegacy(tt.text); got != tt.legacy {
+			t.Errorf("IsLegacy(%q) = %v, want %v", tt.text, got, tt.legacy)
+		}
+	}
+}
+
+func TestLegacyErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"10;0;5-500", []string{
+			`line 1, column 4: "0": step duration must be positive`,
+			`line 1, column 6: "5-500": rate 500 Hz is not usable: flips per second must be <= 100, got: 1000`,
+		}},
+		{"99999999999999999999-3", []string{
+			`line 1, column 1: "99999999999999999999-3": step duration is too long`,
+		}},
+		{"10-0", []string{
+			`line 1, column 1: "10-0": rate 0 Hz is not usable: flips per second must be positive, got: 0`,
+		}},
+		// Not the old form, so reported by the schedule language
+		{"30-40Hz", []string{
+			"line 1, column 3: expected duration unit ms, s or min after 30, found \"-\"",
+		}},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.text, engine.Hertz)
+		var list ErrorList
+		if !errors.As(err, &list) {
+			t.Errorf("%q: got %v, want an ErrorList", tt.text, err)
+			continue
+		}
+		var got []string
+		for _, e := range list {
+			got = append(got, e.Error())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
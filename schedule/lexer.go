@@ -0,0 +1,177 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokWord
+	tokString
+	tokAt
+	tokRange
+	tokPercent
+	tokSeparator
+	tokOpen
+	tokClose
+	tokIllegal
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of schedule"
+	case tokNumber:
+		return "number"
+	case tokWord:
+		return "word"
+	case tokString:
+		return "quoted string"
+	case tokAt:
+		return `"@"`
+	case tokRange:
+		return `".."`
+	case tokPercent:
+		return `"%"`
+	case tokSeparator:
+		return "end of step"
+	case tokOpen:
+		return `"{"`
+	case tokClose:
+		return `"}"`
+	}
+	return "illegal character"
+}
+
+// Pos is a position in the schedule text. Lines and columns start at 1 and
+// columns count characters.
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Col)
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokNumber, tokWord, tokIllegal:
+		return fmt.Sprintf("%q", t.text)
+	case tokString:
+		return strconv.Quote(t.text)
+	case tokSeparator:
+		if t.text == ";" {
+			return `";"`
+		}
+		return "end of line"
+	}
+	return t.kind.String()
+}
+
+// lex splits the schedule text into tokens. Comments are dropped and every
+// newline or semicolon becomes a separator token. Quoted strings use Go
+// syntax and carry their unquoted text.
+func lex(src string) []token {
+	var tokens []token
+	line, col := 1, 1
+	i := 0
+	for i < len(src) {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		pos := Pos{line, col}
+		start := i
+		next := func() {
+			i += size
+			col++
+			if i < len(src) {
+				r, size = utf8.DecodeRuneInString(src[i:])
+			} else {
+				r, size = 0, 0
+			}
+		}
+		switch {
+		case r == '\n':
+			tokens = append(tokens, token{tokSeparator, "\n", pos})
+			i += size
+			line, col = line+1, 1
+			continue
+		case r == ';':
+			tokens = append(tokens, token{tokSeparator, ";", pos})
+			next()
+		case unicode.IsSpace(r):
+			next()
+		case r == '#':
+			for i < len(src) && r != '\n' {
+				next()
+			}
+		case r == '"':
+			quoted, err := strconv.QuotedPrefix(src[i:])
+			if err != nil {
+				// Unterminated, the rest of the line is illegal
+				for i < len(src) && r != '\n' {
+					next()
+				}
+				tokens = append(tokens, token{tokIllegal, src[start:i], pos})
+				continue
+			}
+			for i < start+len(quoted) {
+				next()
+			}
+			text, _ := strconv.Unquote(quoted)
+			tokens = append(tokens, token{tokString, text, pos})
+		case r == '@':
+			tokens = append(tokens, token{tokAt, "@", pos})
+			next()
+		case r == '%':
+			tokens = append(tokens, token{tokPercent, "%", pos})
+			next()
+		case r == '{':
+			tokens = append(tokens, token{tokOpen, "{", pos})
+			next()
+		case r == '}':
+			tokens = append(tokens, token{tokClose, "}", pos})
+			next()
+		case r == '.' && i+1 < len(src) && src[i+1] == '.':
+			next()
+			next()
+			tokens = append(tokens, token{tokRange, "..", pos})
+		case isDigit(r) || r == '.' && i+1 < len(src) && isDigit(rune(src[i+1])):
+			for isDigit(r) {
+				next()
+			}
+			// A dot only belongs to the number when a digit follows, so
+			// "5..40" is a range
+			if r == '.' && i+1 < len(src) && isDigit(rune(src[i+1])) {
+				next()
+				for isDigit(r) {
+					next()
+				}
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], pos})
+		case unicode.IsLetter(r):
+			for unicode.IsLetter(r) || r == '/' {
+				next()
+			}
+			tokens = append(tokens, token{tokWord, src[start:i], pos})
+		default:
+			next()
+			tokens = append(tokens, token{tokIllegal, src[start:i], pos})
+		}
+	}
+	return append(tokens, token{tokEOF, "", Pos{line, col}})
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
@@ -0,0 +1,406 @@
+package schedule
+
+import (
+	"fmt"
+	"gio_flicker/engine"
+	"gio_flicker/stimulus"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse reads a schedule. Rates in the schedule language always carry a
+// unit; defaultUnit only applies to rates in the old syntax. On failure the
+// returned error is an ErrorList with the position of every problem found.
+func Parse(text string, defaultUnit engine.RateUnit) (engine.Schedule, error) {
+	if IsLegacy(text) {
+		items, err := parseLegacy(text, defaultUnit)
+		return engine.Schedule{Items: items}, err
+	}
+	nodes, onEnd, err := parse(text)
+	if err != nil {
+		return engine.Schedule{}, err
+	}
+	return engine.Schedule{Items: expand(nodes, nil), OnEnd: onEnd}, nil
+}
+
+// parse reads text in the schedule language without expanding repeat
+// blocks.
+func parse(text string) ([]node, engine.EndPolicy, error) {
+	p := &parser{tokens: lex(text)}
+	nodes := p.parseSchedule()
+	for t := p.peek(); t.kind == tokClose; t = p.peek() {
+		// A stray "}" without a repeat block, keep checking what follows
+		p.errorf(t.pos, `unexpected "}" outside a repeat block`)
+		p.next()
+		nodes = append(nodes, p.parseSchedule()...)
+	}
+	// Repeat blocks check their own size, the steps side by side are
+	// checked here
+	total := 0
+	for _, nd := range nodes {
+		total += size([]node{nd})
+		if total > maxSteps {
+			p.errorf(nd.pos, "the schedule expands to more than %d steps", maxSteps)
+			break
+		}
+	}
+	return nodes, p.onEnd, p.errs.Err()
+}
+
+// node is a step or a repeat block of the parsed schedule.
+type node struct {
+	item  engine.ScheduleItem
+	count int // repeat count of a block, 0 for a single step
+	body  []node
+	pos   Pos // start of the step or block in the text, zero for documents
+}
+
+// size returns the number of steps nodes expand to. It stops counting
+// just above maxSteps, so huge repeat counts can't overflow.
+func size(nodes []node) int {
+	n := 0
+	for _, nd := range nodes {
+		steps := 1
+		if nd.count > 0 {
+			body := size(nd.body)
+			if body > 0 && nd.count > maxSteps/body {
+				return maxSteps + 1
+			}
+			steps = nd.count * body
+		}
+		n = min(n+steps, maxSteps+1)
+	}
+	return n
+}
+
+// expand appends the steps of nodes to items with repeat blocks written out.
+func expand(nodes []node, items []engine.ScheduleItem) []engine.ScheduleItem {
+	for _, nd := range nodes {
+		if nd.count == 0 {
+			items = append(items, nd.item)
+			continue
+		}
+		for range nd.count {
+			items = expand(nd.body, items)
+		}
+	}
+	return items
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	errs   ErrorList
+	depth  int // nesting of repeat blocks
+	onEnd  engine.EndPolicy
+	endPos *Pos // position of the "on end" line, once seen
+}
+
+// bailout aborts the current step after an error has been recorded.
+type bailout struct{}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) errorf(pos Pos, format string, args ...any) {
+	p.errs = append(p.errs, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// fail records an error at t and abandons the current step.
+func (p *parser) fail(t token, format string, args ...any) {
+	p.errorf(t.pos, format, args...)
+	panic(bailout{})
+}
+
+// expect consumes a token of the given kind. A mismatching token is left
+// in place so recovery does not skip past a separator.
+func (p *parser) expect(kind tokenKind, what string) token {
+	t := p.peek()
+	if t.kind != kind {
+		p.fail(t, "expected %s, found %s", what, t)
+	}
+	return p.next()
+}
+
+// isWord reports whether t is the keyword w, ignoring case.
+func isWord(t token, w string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, w)
+}
+
+// maxSteps limits how many steps repeat blocks may expand to.
+const maxSteps = 100000
+
+// parseSchedule parses steps up to the end of the text or of the enclosing
+// repeat block.
+func (p *parser) parseSchedule() []node {
+	var nodes []node
+	for {
+		for p.peek().kind == tokSeparator {
+			p.next()
+		}
+		if k := p.peek().kind; k == tokEOF || k == tokClose {
+			return nodes
+		}
+		pos := p.peek().pos
+		for _, nd := range p.parseStep() {
+			nd.pos = pos
+			nodes = append(nodes, nd)
+		}
+	}
+}
+
+// endOfStep reports whether t ends a step.
+func endOfStep(t token) bool {
+	return t.kind == tokSeparator || t.kind == tokEOF || t.kind == tokClose
+}
+
+// parseStep parses one step or repeat block. After an error it skips to the
+// end of the step so later steps are still checked.
+func (p *parser) parseStep() (nodes []node) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isBailout := r.(bailout); !isBailout {
+				panic(r)
+			}
+			for !endOfStep(p.peek()) {
+				p.next()
+			}
+			nodes = nil
+		}
+	}()
+
+	if isWord(p.peek(), "repeat") {
+		return p.parseRepeat()
+	}
+	if isWord(p.peek(), "on") {
+		p.parseOnEnd()
+		return nil
+	}
+	var item engine.ScheduleItem
+	item.Duration = p.parseDuration()
+	t := p.peek()
+	if !endOfStep(t) {
+		p.next()
+	}
+	switch {
+	case t.kind == tokAt || isWord(t, "flicker"):
+		item.FlickeringRate = p.parseRate()
+	case isWord(t, "blank"):
+		item.Blank = true
+	case isWord(t, "sweep"):
+		item.FlickeringRate = p.parseRate()
+		p.expect(tokRange, `".." between the start and end rate`)
+		item.EndRate = p.parseRate()
+		item.Sweep = engine.LinearSweep
+		if t := p.peek(); isWord(t, "linear") || isWord(t, "log") {
+			item.Sweep, _ = engine.ParseSweep(p.next().text)
+		}
+	default:
+		p.fail(t, `expected "@", "flicker", "blank" or "sweep" after the duration, found %s`, t)
+	}
+	if !item.Blank {
+		p.parseOptions(&item)
+	}
+
+	p.expectEndOfStep()
+	return []node{{item: item}}
+}
+
+func (p *parser) expectEndOfStep() {
+	if t := p.peek(); !endOfStep(t) {
+		p.fail(t, "expected end of step, found %s", t)
+	}
+}
+
+// parseRepeat parses "repeat N { steps }". Blocks may be nested.
+func (p *parser) parseRepeat() []node {
+	keyword := p.next()
+	n := p.expect(tokNumber, "repeat count")
+	count, err := strconv.Atoi(n.text)
+	if err != nil || count < 1 {
+		// Keep parsing the block so its "}" is not reported as well
+		p.errorf(n.pos, "repeat count must be a whole number of at least 1, found %s", n.text)
+		count = 0
+	}
+	p.expect(tokOpen, `"{" after the repeat count`)
+	errs := len(p.errs)
+	p.depth++
+	body := p.parseSchedule()
+	p.depth--
+	if t := p.peek(); t.kind != tokClose {
+		p.errorf(keyword.pos, "repeat block is not closed")
+		p.fail(t, `expected "}", found %s`, t)
+	}
+	p.next()
+	p.expectEndOfStep()
+	if count == 0 {
+		return nil
+	}
+	if len(body) == 0 && len(p.errs) == errs {
+		p.fail(keyword, "repeat block has no steps")
+	}
+	if count > maxSteps/max(1, size(body)) {
+		p.fail(keyword, "repeat block expands to more than %d steps", maxSteps)
+	}
+	return []node{{count: count, body: body}}
+}
+
+func (p *parser) parseOptions(item *engine.ScheduleItem) {
+	for {
+		t := p.peek()
+		switch {
+		case isWord(t, "duty"):
+			p.next()
+			n := p.expect(tokNumber, "duty cycle percentage")
+			p.expect(tokPercent, `"%" after the duty cycle`)
+			percent, _ := strconv.ParseFloat(n.text, 64)
+			if err := engine.ValidateDutyCycle(percent / 100); err != nil {
+				p.fail(n, "%v", err)
+			}
+			item.DutyCycle = percent / 100
+		case isWord(t, "wave"):
+			p.next()
+			w := p.expect(tokWord, "waveform name")
+			waveform, err := engine.ParseWaveform(w.text)
+			if err != nil {
+				p.fail(w, "unknown waveform %q, expected square, sine, triangle or sawtooth", w.text)
+			}
+			item.Waveform = waveform
+		case isWord(t, "show"):
+			p.next()
+			setSource(item, p.parseSource())
+		default:
+			return
+		}
+	}
+}
+
+// parseSource parses the stimulus of a step after "show": images, pattern
+// or color followed by quoted strings.
+func (p *parser) parseSource() stimulus.Source {
+	kind := p.expect(tokWord, "images, pattern or color")
+	var values []string
+	for p.peek().kind == tokString {
+		values = append(values, p.next().text)
+	}
+	if len(values) == 0 {
+		p.fail(p.peek(), "expected a quoted string after %s, found %s", kind.text, p.peek())
+	}
+	src, err := stimulus.NewSource(kind.text, values)
+	if err == nil {
+		err = src.Validate()
+	}
+	if err != nil {
+		p.fail(kind, "%v", err)
+	}
+	return src
+}
+
+// setSource makes item show src instead of the session stimulus.
+func setSource(item *engine.ScheduleItem, src stimulus.Source) {
+	item.Stimulus = src.String()
+	item.Frames = src.Frames()
+}
+
+// parseOnEnd parses the end policy line "on end stop", "on end loop",
+// "on end loop N" or "on end blank".
+func (p *parser) parseOnEnd() {
+	on := p.next()
+	if p.depth > 0 {
+		p.fail(on, `"on end" applies to the whole schedule and can't be inside a repeat block`)
+	}
+	if p.endPos != nil {
+		p.fail(on, `"on end" is already set at %s`, *p.endPos)
+	}
+	if t := p.peek(); !isWord(t, "end") {
+		p.fail(t, `expected "end" after "on", found %s`, t)
+	}
+	p.next()
+	policy := p.parseEndPolicy()
+	p.expectEndOfStep()
+	p.onEnd = policy
+	p.endPos = &on.pos
+}
+
+// parseEndPolicy parses what follows "on end".
+func (p *parser) parseEndPolicy() engine.EndPolicy {
+	t := p.peek()
+	var policy engine.EndPolicy
+	switch {
+	case isWord(t, "stop"):
+		policy.Action = engine.StopAtEnd
+	case isWord(t, "blank"):
+		policy.Action = engine.HoldBlank
+	case isWord(t, "loop"):
+		policy.Action = engine.LoopForever
+	default:
+		p.fail(t, `expected "stop", "loop" or "blank" after "on end", found %s`, t)
+	}
+	p.next()
+	if n := p.peek(); policy.Action == engine.LoopForever && n.kind == tokNumber {
+		p.next()
+		times, err := strconv.Atoi(n.text)
+		if err != nil || times < 1 {
+			p.fail(n, "loop count must be a whole number of at least 1, found %s", n.text)
+		}
+		policy = engine.EndPolicy{Action: engine.LoopTimes, Times: times}
+	}
+	return policy
+}
+
+var durationUnits = map[string]time.Duration{
+	"ms":  time.Millisecond,
+	"s":   time.Second,
+	"min": time.Minute,
+}
+
+func (p *parser) parseDuration() time.Duration {
+	n := p.expect(tokNumber, "step duration such as 16s")
+	unitTok := p.peek()
+	unit, ok := durationUnits[strings.ToLower(unitTok.text)]
+	if unitTok.kind != tokWord || !ok {
+		p.fail(unitTok, "expected duration unit ms, s or min after %s, found %s", n.text, unitTok)
+	}
+	p.next()
+	value, _ := strconv.ParseFloat(n.text, 64)
+	ns := value * float64(unit)
+	if ns >= math.MaxInt64 {
+		// The conversion would overflow
+		p.fail(n, "step duration is too long")
+	}
+	d := time.Duration(ns)
+	if d <= 0 {
+		p.fail(n, "step duration must be positive")
+	}
+	return d
+}
+
+func (p *parser) parseRate() engine.Rate {
+	n := p.expect(tokNumber, "rate such as 12Hz")
+	value, _ := strconv.ParseFloat(n.text, 64)
+	var rate engine.Rate
+	switch t := p.peek(); {
+	case isWord(t, "hz"):
+		rate = engine.Hz(value)
+	case isWord(t, "flips/s"):
+		rate = engine.Flips(value)
+	default:
+		p.fail(t, "expected rate unit Hz or flips/s after %s, found %s", n.text, t)
+	}
+	p.next()
+	if _, err := engine.FlipPeriod(rate.FlipsPerSecond()); err != nil {
+		p.fail(n, "rate %s is not usable: %v", rate.Short(), err)
+	}
+	return rate
+}
@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"errors"
+	"gio_flicker/engine"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("repeat 2 {\n  repeat 3 { 1s @ 10Hz; 500ms blank }\n}\non end loop 2", engine.Hertz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Items) != 12 {
+		t.Fatalf("%d steps, want 12", len(s.Items))
+	}
+	if s.OnEnd != (engine.EndPolicy{Action: engine.LoopTimes, Times: 2}) {
+		t.Errorf("on end %v, want loop 2", s.OnEnd)
+	}
+	first, second := s.Items[0], s.Items[1]
+	if first.Duration != time.Second || first.FlickeringRate != engine.Hz(10) || first.Blank {
+		t.Errorf("first step %+v, want 1s at 10 Hz", first)
+	}
+	if second.Duration != 500*time.Millisecond || !second.Blank {
+		t.Errorf("second step %+v, want 500ms blank", second)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string // messages with their position, in order
+	}{
+		{"10s @ 40\n5s blank", []string{
+			"line 1, column 9: expected rate unit Hz or flips/s after 40, found end of line",
+		}},
+		{"10s @ 40Hz duty 150%", []string{
+			"line 1, column 17: duty cycle must be between 0% and 100%, got: 150%",
+		}},
+		{"10s @ 40Hz\n  5s @ 12Hz wave wobble", []string{
+			`line 2, column 18: unknown waveform "wobble", expected square, sine, triangle or sawtooth`,
+		}},
+		{"10s @ 40Hz\n200000000000min blank", []string{
+			"line 2, column 1: step duration is too long",
+		}},
+		{"0s blank", []string{
+			"line 1, column 1: step duration must be positive",
+		}},
+		{"repeat 0 { 1s blank }", []string{
+			"line 1, column 8: repeat count must be a whole number of at least 1, found 0",
+		}},
+		{"repeat 3 { 1s blank", []string{
+			"line 1, column 1: repeat block is not closed",
+			`line 1, column 20: expected "}", found end of schedule`,
+		}},
+		{"}", []string{
+			`line 1, column 1: unexpected "}" outside a repeat block`,
+		}},
+		{"10s blank\non end nonsense", []string{
+			`line 2, column 8: expected "stop", "loop" or "blank" after "on end", found "nonsense"`,
+		}},
+		{"repeat 100001 { 1s blank }", []string{
+			"line 1, column 1: repeat block expands to more than 100000 steps",
+		}},
+		// The count times the steps would wrap around to 0
+		{"repeat 4611686018427387904 { 1s blank; 1s blank; 1s blank; 1s blank }", []string{
+			"line 1, column 1: repeat block expands to more than 100000 steps",
+		}},
+		// Every block fits, together they don't
+		{strings.Repeat("repeat 100000 { 1s blank }\n", 5), []string{
+			"line 2, column 1: the schedule expands to more than 100000 steps",
+		}},
+		{"10s blank\nrepeat 100000 { 1s blank }", []string{
+			"line 2, column 1: the schedule expands to more than 100000 steps",
+		}},
+		{"repeat 4294967296 {\n  repeat 4294967296 { 1s blank }\n}", []string{
+			"line 2, column 3: repeat block expands to more than 100000 steps",
+			"line 1, column 1: repeat block expands to more than 100000 steps",
+		}},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.text, engine.Hertz)
+		var list ErrorList
+		if !errors.As(err, &list) {
+			t.Errorf("%q: got %v, want an ErrorList", tt.text, err)
+			continue
+		}
+		got := make([]string, len(list))
+		for i, e := range list {
+			got[i] = e.Error()
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSizeSaturates(t *testing.T) {
+	inner := []node{{count: 1 << 32, body: []node{{}}}}
+	if n := size([]node{{count: 1 << 32, body: inner}}); n != maxSteps+1 {
+		t.Errorf("size %d, want %d", n, maxSteps+1)
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	// Exactly at the limit, from a block and a step side by side
+	s, err := Parse("repeat 99999 { 1s blank }\n1s @ 10Hz", engine.Hertz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Items) != maxSteps {
+		t.Errorf("%d steps, want %d", len(s.Items), maxSteps)
+	}
+}
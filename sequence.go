@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"gio_flicker/engine"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hand the number of stimulus frames and their durations to the engine.
+// A running session starts over when the sequence changed.
+func updateSequence(ui *UI) {
+	// New images or patterns are checked again before they are shown
+	ui.safety.stale = true
+	seq := engine.Sequence{Frames: len(ui.images)}
+	if ui.pattern != nil {
+		seq.Frames = ui.pattern.Phases
+	}
+	var err error
+	seq.Durations, err = parseFrameDurations(ui.frameEditor.Text())
+	if err == nil {
+		err = seq.Validate()
+	}
+	ui.sequenceErr = err
+	if err != nil {
+		// Without valid durations the frames follow the rate
+		seq.Durations = nil
+	}
+	old := ui.engine.Sequence()
+	if old.Len() == seq.Len() && slices.Equal(old.Durations, seq.Durations) {
+		return
+	}
+	if err := ui.engine.SetSequence(seq); err != nil {
+		ui.sequenceErr = err
+		return
+	}
+	if len(seq.Durations) > 0 {
+		log.Printf("Stimulus sequence of %d frames shown for %s", seq.Len(), formatFrameDurations(seq.Durations))
+	} else {
+		log.Printf("Stimulus sequence of %d frames", seq.Len())
+	}
+	ui.engine.Restart()
+}
+
+// Parse frame durations in milliseconds such as "100, 100, 50, 50". An
+// empty text means the frames follow the rate.
+func parseFrameDurations(text string) ([]time.Duration, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' })
+	var durations []time.Duration
+	for _, f := range fields {
+		ms, err := strconv.ParseFloat(f, 64)
+		if err != nil || !(ms > 0) {
+			return nil, fmt.Errorf("frame durations are milliseconds, not %q", f)
+		}
+		durations = append(durations, time.Duration(ms*float64(time.Millisecond)))
+	}
+	return durations, nil
+}
+
+// Format frame durations as milliseconds, e.g. "100, 100, 50, 50"
+func formatFrameDurations(durations []time.Duration) string {
+	parts := make([]string, len(durations))
+	for i, d := range durations {
+		parts[i] = strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	}
+	return strings.Join(parts, ", ")
+}
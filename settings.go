@@ -0,0 +1,130 @@
+package main
+
+import (
+	"gio_flicker/config"
+	"gio_flicker/engine"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applySettings puts the preferences of the last run into the UI and loads
+// the last schedule: the library schedule that was in use, unless a
+// schedule file was given on the command line, or else the schedule file.
+func applySettings(ui *UI, scheduleFlag bool) {
+	s := ui.settings
+	ui.limits = newLimitTracker(s)
+	if s.Rate > 0 {
+		ui.rateEditor.SetText(strconv.FormatFloat(s.Rate, 'f', -1, 64))
+	}
+	if s.RateUnit == engine.Hertz.String() {
+		ui.rateUnit = engine.Hertz
+	}
+	if s.DutyCycle > 0 {
+		ui.dutyEditor.SetText(strconv.FormatFloat(s.DutyCycle, 'f', -1, 64))
+	}
+	if w, err := engine.ParseWaveform(s.Waveform); err == nil && w != engine.DefaultWaveform {
+		ui.waveform = w
+	}
+	if s.RefreshHz > 0 {
+		ui.refreshEditor.SetText(strconv.FormatFloat(s.RefreshHz, 'f', -1, 64))
+	}
+	ui.frameLocked = s.FrameLocked
+	if s.FadeS > 0 {
+		ui.fadeEditor.SetText(strconv.FormatFloat(s.FadeS, 'f', -1, 64))
+	}
+	ui.fadeRate = s.FadeRate
+	if s.Viewing.DistanceCM > 0 {
+		ui.distanceEditor.SetText(strconv.FormatFloat(s.Viewing.DistanceCM, 'f', -1, 64))
+	}
+	if s.Viewing.PixelsPerCM > 0 {
+		ui.densityEditor.SetText(strconv.FormatFloat(s.Viewing.PixelsPerCM, 'f', -1, 64))
+	}
+	parseViewing(ui)
+	durations := make([]time.Duration, len(s.FrameMS))
+	for i, ms := range s.FrameMS {
+		durations[i] = time.Duration(ms * float64(time.Millisecond))
+	}
+	ui.frameEditor.SetText(formatFrameDurations(durations))
+	ui.blankEditor.SetText(s.Blank.Color)
+	parseBlankColor(ui)
+	loadBlankImage(ui, s.Blank.Image)
+	ui.fixationEditor.SetText(s.Fixation.Spec)
+	parseFixation(ui)
+	ui.fixation.blanksOnly = s.Fixation.BlanksOnly
+	ui.patternEditor.SetText(s.Pattern)
+	parsePattern(ui)
+
+	picked := false
+	if s.Schedule != "" && !scheduleFlag {
+		if e, ok := ui.library.Find(s.Schedule); ok {
+			pickSchedule(ui, e)
+			picked = true
+		}
+	}
+	if !picked {
+		loadSchedule(ui)
+	}
+	ui.useSchedule = s.UseSchedule && ui.scheduleErr == nil
+
+	// Hand the rate, duty cycle and waveform to the engine
+	changeRate(ui)
+}
+
+// saveSettings stores the preferences for the next run.
+func saveSettings(ui *UI) {
+	if ui.configDir == "" {
+		return
+	}
+	s := ui.settings
+	s.Rate = 0
+	if rate, err := strconv.ParseFloat(ui.rateEditor.Text(), 64); err == nil {
+		s.Rate = rate
+	}
+	s.RateUnit = ui.rateUnit.String()
+	s.DutyCycle = 0
+	if duty, err := parseDuty(ui.dutyEditor.Text()); err == nil && ui.dutyEditor.Text() != "" {
+		s.DutyCycle = duty * 100
+	}
+	s.Waveform = ui.waveform.String()
+	s.UseSchedule = ui.useSchedule
+	s.Schedule = ui.picker.selected
+	s.FrameLocked = ui.frameLocked
+	s.RefreshHz = 0
+	if hz, err := strconv.ParseFloat(ui.refreshEditor.Text(), 64); err == nil && hz >= 1 {
+		s.RefreshHz = hz
+	}
+	s.FadeS = fadeDuration(ui).Seconds()
+	s.FadeRate = ui.fadeRate
+	s.Images = nil
+	if slices.ContainsFunc(ui.imagePaths, func(path string) bool { return path != "" }) {
+		s.Images = ui.imagePaths
+	}
+	s.FrameMS = nil
+	if durations, err := parseFrameDurations(ui.frameEditor.Text()); err == nil {
+		for _, d := range durations {
+			s.FrameMS = append(s.FrameMS, float64(d)/float64(time.Millisecond))
+		}
+	}
+	s.Blank = config.Blank{
+		Color: strings.TrimSpace(ui.blankEditor.Text()),
+		Image: ui.blank.imagePath,
+	}
+	s.Fixation = config.Fixation{
+		Spec:       strings.TrimSpace(ui.fixationEditor.Text()),
+		BlanksOnly: ui.fixation.blanksOnly,
+	}
+	s.Exposure = exposureSettings(ui.limits)
+	s.Pattern = strings.TrimSpace(ui.patternEditor.Text())
+	s.Viewing = ui.viewing
+	if ui.windowSize.Width > 0 {
+		s.Window = ui.windowSize
+	}
+	if err := config.Save(ui.configDir, s); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		return
+	}
+	ui.settings = s
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gio_flicker/engine"
+	"gio_flicker/schedule"
+	"gio_flicker/stimulus"
+	"gioui.org/op/paint"
+	"image"
+	"image/color"
+	"path/filepath"
+)
+
+// Stimulus of schedule steps that show their own images, pattern or colors
+type stepStimulus struct {
+	source  stimulus.Source
+	pattern *stimulus.Pattern
+	colors  []color.NRGBA
+	frames  []IMG       // loaded images, or the pattern or colors rendered at size
+	size    image.Point // zero when the frames have to be rendered again
+}
+
+// Load the images and check the patterns of every step stimulus in the
+// schedule, so a session never starts with a step that can't be shown
+func loadStepStimuli(ui *UI, s engine.Schedule) error {
+	stimuli := make(map[string]*stepStimulus)
+	var errs []error
+	for i, item := range s.Items {
+		if item.Stimulus == "" || stimuli[item.Stimulus] != nil {
+			continue
+		}
+		st, err := newStepStimulus(ui, item.Stimulus)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule step %d: %w", i+1, err))
+			continue
+		}
+		stimuli[item.Stimulus] = st
+	}
+	ui.stepStimuli = stimuli
+	return errors.Join(errs...)
+}
+
+func newStepStimulus(ui *UI, spec string) (*stepStimulus, error) {
+	src, err := stimulus.ParseSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	st := &stepStimulus{source: src}
+	switch {
+	case src.Images != nil:
+		for _, path := range src.Images {
+			img, err := loadImageFile(schedule.ResolvePath(scheduleDir(ui), path))
+			if err != nil {
+				return nil, err
+			}
+			st.frames = append(st.frames, img)
+		}
+		if err := matchSizes(st.frames); err != nil {
+			return nil, err
+		}
+	case src.Pattern != "":
+		p, err := stimulus.Parse(src.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.Pixels(ui.viewing); err != nil {
+			return nil, err
+		}
+		st.pattern = &p
+	default:
+		for _, c := range src.Colors {
+			nrgba, err := stimulus.ParseColor(c)
+			if err != nil {
+				return nil, err
+			}
+			st.colors = append(st.colors, nrgba)
+		}
+	}
+	return st, nil
+}
+
+// Folder that relative image paths of a schedule start from
+func scheduleDir(ui *UI) string {
+	if ui.picker.selected != "" && ui.library.Dir() != "" {
+		return ui.library.Dir()
+	}
+	return filepath.Dir(ui.schedulePath)
+}
+
+// Return the frames of the state in an area of size pixels: the stimulus
+// of the schedule step, or else the session stimulus
+func frameImages(ui *UI, item engine.ScheduleItem, size image.Point) []IMG {
+	st := ui.stepStimuli[item.Stimulus]
+	if item.Stimulus == "" || st == nil {
+		return stimulusImages(ui, size)
+	}
+	if st.source.Images != nil || size == st.size || size.X <= 0 || size.Y <= 0 {
+		return st.frames
+	}
+	// Patterns and colors are drawn pixel for pixel at the size of the area
+	st.size = size
+	st.frames = nil
+	if st.pattern != nil {
+		frames, err := st.pattern.Render(size, ui.viewing)
+		if err != nil {
+			st.size = image.Point{}
+			return stimulusImages(ui, size)
+		}
+		gray := st.pattern.MeanGray()
+		for _, frame := range frames {
+			st.frames = append(st.frames, IMG{
+				imgOp:   paint.NewImageOp(frame),
+				imgSize: size,
+				mean:    color.NRGBA{R: gray, G: gray, B: gray, A: 255},
+				src:     frame,
+			})
+		}
+		return st.frames
+	}
+	for _, c := range st.colors {
+		img := stimulus.Fill(size, c)
+		st.frames = append(st.frames, IMG{imgOp: paint.NewImageOp(img), imgSize: size, mean: c, src: img})
+	}
+	return st.frames
+}
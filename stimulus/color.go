@@ -0,0 +1,74 @@
+package stimulus
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// srgbToLinear maps 8-bit sRGB values to linear light of 0 to 1.
+var srgbToLinear = func() (lut [256]float64) {
+	for i := range lut {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			lut[i] = v / 12.92
+		} else {
+			lut[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return lut
+}()
+
+// MeanColor returns the color with the mean light of img. The mean is
+// taken in linear light, so a uniform field of this color matches the
+// luminance of the image seen from a distance.
+func MeanColor(img image.Image) color.NRGBA {
+	b := img.Bounds()
+	var r, g, bl float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r += srgbToLinear[c.R]
+			g += srgbToLinear[c.G]
+			bl += srgbToLinear[c.B]
+		}
+	}
+	n := float64(b.Dx() * b.Dy())
+	if n == 0 {
+		return color.NRGBA{A: 255}
+	}
+	return color.NRGBA{R: encodeSRGB(r / n), G: encodeSRGB(g / n), B: encodeSRGB(bl / n), A: 255}
+}
+
+// Luminance returns the relative luminance of c, 0 for black and 1 for
+// white.
+func Luminance(c color.NRGBA) float64 {
+	r, g, b := Linear(c)
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// Gray returns the neutral gray of relative luminance l.
+func Gray(l float64) color.NRGBA {
+	v := encodeSRGB(l)
+	return color.NRGBA{R: v, G: v, B: v, A: 255}
+}
+
+// Linear returns the channels of c in linear light of 0 to 1.
+func Linear(c color.NRGBA) (r, g, b float64) {
+	return srgbToLinear[c.R], srgbToLinear[c.G], srgbToLinear[c.B]
+}
+
+// MixColors returns the mean of colors in linear light.
+func MixColors(colors ...color.NRGBA) color.NRGBA {
+	if len(colors) == 0 {
+		return color.NRGBA{A: 255}
+	}
+	var r, g, b float64
+	for _, c := range colors {
+		r += srgbToLinear[c.R]
+		g += srgbToLinear[c.G]
+		b += srgbToLinear[c.B]
+	}
+	n := float64(len(colors))
+	return color.NRGBA{R: encodeSRGB(r / n), G: encodeSRGB(g / n), B: encodeSRGB(b / n), A: 255}
+}
@@ -0,0 +1,227 @@
+package stimulus
+
+import (
+	"fmt"
+	"image/color"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mark is the shape of a fixation mark.
+type Mark int
+
+const (
+	Cross    Mark = iota
+	Dot           // filled disc
+	Bullseye      // ring around a dot
+)
+
+var markNames = [...]string{"cross", "dot", "bullseye"}
+
+func (m Mark) String() string {
+	if m < 0 || int(m) >= len(markNames) {
+		return fmt.Sprintf("Mark(%d)", int(m))
+	}
+	return markNames[m]
+}
+
+// Fixation describes the mark participants look at in the middle of the
+// screen. It is written as a spec like a pattern:
+//
+//	cross                          # 20 pixel cross, 2 pixels thick
+//	dot 0.3deg color #ff0000
+//	bullseye 1deg thickness 0.1deg
+//	cross change #ff0000 every 8s  # turns red for 500ms about every 8 s
+//
+// Color changes come at random times around the given interval, to keep
+// participants attending to the mark.
+type Fixation struct {
+	Mark      Mark
+	Size      float64 // width of the cross, diameter of the dot or ring
+	Unit      SizeUnit
+	Thickness float64     // line width of the cross and ring, in Unit
+	Color     color.NRGBA // zero for black or white, whichever stands out
+
+	Change      color.NRGBA   // color during changes, zero for none
+	ChangeEvery time.Duration // mean time between changes
+	ChangeFor   time.Duration // how long a change lasts
+}
+
+// DefaultFixation returns the mark with the default size and options.
+func DefaultFixation(mark Mark) Fixation {
+	f := Fixation{Mark: mark, Size: 20, Thickness: 2, ChangeFor: 500 * time.Millisecond}
+	if mark == Dot {
+		f.Size = 8
+	}
+	return f
+}
+
+// fixationToken splits a number from its unit, so "20px" and "20 px" read
+// the same.
+var fixationToken = regexp.MustCompile(`^(-?[0-9]*\.?[0-9]+)([a-z%]+)$`)
+
+// ParseFixation reads a fixation spec such as "cross 0.5deg color #000".
+func ParseFixation(spec string) (Fixation, error) {
+	var tokens []string
+	for _, t := range strings.Fields(strings.ToLower(spec)) {
+		if m := fixationToken.FindStringSubmatch(t); m != nil {
+			tokens = append(tokens, m[1], m[2])
+		} else {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		return Fixation{}, fmt.Errorf("empty fixation mark, expected %s", strings.Join(markNames[:], ", "))
+	}
+	mark := -1
+	for i, name := range markNames {
+		if tokens[0] == name {
+			mark = i
+		}
+	}
+	if mark < 0 {
+		return Fixation{}, fmt.Errorf("unknown fixation mark %q, expected %s", tokens[0], strings.Join(markNames[:], ", "))
+	}
+	f := DefaultFixation(Mark(mark))
+	tokens = tokens[1:]
+
+	next := func() string {
+		if len(tokens) == 0 {
+			return ""
+		}
+		t := tokens[0]
+		tokens = tokens[1:]
+		return t
+	}
+	number := func(what string) (float64, error) {
+		t := next()
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil || !(v > 0) {
+			return 0, fmt.Errorf("expected %s, found %q", what, t)
+		}
+		return v, nil
+	}
+	// A size with its unit, which has to match the unit of the mark size
+	size := func(what string, unit *SizeUnit) (float64, error) {
+		v, err := number(what)
+		if err != nil {
+			return 0, err
+		}
+		switch t := next(); t {
+		case "px":
+			*unit = Pixels
+		case "deg":
+			*unit = Degrees
+		default:
+			return 0, fmt.Errorf("expected size unit px or deg after %g, found %q", v, t)
+		}
+		return v, nil
+	}
+	duration := func(what string) (time.Duration, error) {
+		v, err := number(what)
+		if err != nil {
+			return 0, err
+		}
+		switch t := next(); t {
+		case "ms":
+			return time.Duration(v * float64(time.Millisecond)), nil
+		case "s":
+			return time.Duration(v * float64(time.Second)), nil
+		default:
+			return 0, fmt.Errorf("expected duration unit ms or s after %g, found %q", v, t)
+		}
+	}
+
+	// An optional size comes first
+	if len(tokens) > 0 {
+		if _, err := strconv.ParseFloat(tokens[0], 64); err == nil {
+			v, err := size("mark size", &f.Unit)
+			if err != nil {
+				return Fixation{}, err
+			}
+			// Keep the default line width in proportion
+			f.Thickness *= v / f.Size
+			f.Size = v
+		}
+	}
+	for len(tokens) > 0 {
+		var err error
+		switch t := next(); {
+		case t == "thickness" && f.Mark != Dot:
+			var unit SizeUnit
+			f.Thickness, err = size("line thickness", &unit)
+			if err == nil && unit != f.Unit {
+				err = fmt.Errorf("the thickness must be in %s like the size", f.Unit)
+			}
+		case t == "color":
+			f.Color, err = ParseColor(next())
+		case t == "change":
+			if f.Change, err = ParseColor(next()); err != nil {
+				break
+			}
+			if t := next(); t != "every" {
+				err = fmt.Errorf(`expected "every" after the change color, found %q`, t)
+				break
+			}
+			f.ChangeEvery, err = duration("time between changes")
+			if err == nil && len(tokens) > 0 && tokens[0] == "for" {
+				next()
+				f.ChangeFor, err = duration("duration of a change")
+			}
+			if err == nil && f.ChangeFor >= f.ChangeEvery/2 {
+				err = fmt.Errorf("changes must be shorter than half the time between them")
+			}
+		default:
+			err = fmt.Errorf("unexpected %q for a %s", t, f.Mark)
+		}
+		if err != nil {
+			return Fixation{}, err
+		}
+	}
+	return f, nil
+}
+
+// String writes the spec of the mark, which ParseFixation reads back.
+func (f Fixation) String() string {
+	d := DefaultFixation(f.Mark)
+	parts := []string{f.Mark.String()}
+	if f.Size != d.Size || f.Unit != Pixels {
+		parts = append(parts, formatNumber(f.Size)+f.Unit.String())
+		d.Thickness *= f.Size / d.Size
+	}
+	if f.Mark != Dot && f.Thickness != d.Thickness {
+		parts = append(parts, "thickness", formatNumber(f.Thickness)+f.Unit.String())
+	}
+	if f.Color.A != 0 {
+		parts = append(parts, "color", formatColor(f.Color))
+	}
+	if f.Change.A != 0 {
+		parts = append(parts, "change", formatColor(f.Change), "every", formatDuration(f.ChangeEvery))
+		if f.ChangeFor != d.ChangeFor {
+			parts = append(parts, "for", formatDuration(f.ChangeFor))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Pixels returns the size and line thickness of the mark in pixels.
+func (f Fixation) Pixels(v Viewing) (size, thickness float64, err error) {
+	if f.Unit == Pixels {
+		return f.Size, f.Thickness, nil
+	}
+	ppd, err := v.PixelsPerDegree()
+	return f.Size * ppd, f.Thickness * ppd, err
+}
+
+func formatDuration(d time.Duration) string {
+	if d%time.Second == 0 {
+		return formatNumber(d.Seconds()) + "s"
+	}
+	return formatNumber(float64(d)/float64(time.Millisecond)) + "ms"
+}
+
+func formatColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
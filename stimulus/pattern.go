@@ -0,0 +1,235 @@
+// Package stimulus generates flicker stimuli procedurally at the
+// resolution they are shown at, instead of scaling bitmaps.
+//
+// A pattern is described by a short spec, the kind followed by its size and
+// options:
+//
+//	checkerboard 64px             # square checks 64 pixels wide
+//	checkerboard 0.5deg contrast 50%
+//	radial 1deg wedges 24         # polar checkerboard, rings 1 degree wide
+//	grating 2deg angle 45 square  # grating with a 2 degree period
+//	solid mean 30%                # uniform field flickering in luminance
+//	grating 1deg phases 4         # grating drifting a quarter period per frame
+//
+// Sizes are in pixels (px) or degrees of visual angle (deg), which needs
+// the viewing distance and pixel density. By default a pattern renders as
+// a counter-phase pair: the second image is the first with its contrast
+// reversed, so both have the same mean luminance. With more phases the
+// pattern moves by an equal share of its cycle from frame to frame:
+// gratings and checks drift, radial wedges rotate.
+//
+// A Source names what a schedule step shows: images, a pattern or colors.
+package stimulus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kind is the shape of a pattern.
+type Kind int
+
+const (
+	Checkerboard Kind = iota
+	Radial            // polar checkerboard of rings and wedges
+	Grating           // bars with a sine or square profile
+	Solid             // uniform field
+)
+
+var kindNames = [...]string{"checkerboard", "radial", "grating", "solid"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// SizeUnit is the unit of a pattern size.
+type SizeUnit int
+
+const (
+	Pixels SizeUnit = iota
+	Degrees
+)
+
+func (u SizeUnit) String() string {
+	if u == Degrees {
+		return "deg"
+	}
+	return "px"
+}
+
+// Pattern describes a generated stimulus.
+type Pattern struct {
+	Kind     Kind
+	Size     float64 // check width, ring width or grating period
+	Unit     SizeUnit
+	Contrast float64 // Michelson contrast, 0 to 1
+	Mean     float64 // mean luminance, 0 to 1 of the display maximum
+	Wedges   int     // number of wedges of a radial checkerboard
+	Angle    float64 // grating orientation in degrees, 0 gives vertical bars
+	Square   bool    // square grating profile instead of sine
+	Phases   int     // number of frames the pattern cycles through
+}
+
+// Default returns the pattern of kind with the default size and options.
+func Default(kind Kind) Pattern {
+	p := Pattern{Kind: kind, Size: 64, Contrast: 1, Mean: 0.5, Phases: 2}
+	switch kind {
+	case Radial:
+		p.Wedges = 16
+	case Grating:
+		p.Size = 128
+	}
+	return p
+}
+
+// specToken splits a spec into numbers and words, so "64px" and "64 px"
+// read the same.
+var specToken = regexp.MustCompile(`-?[0-9]*\.?[0-9]+|[a-z]+|%|\S`)
+
+// Parse reads a pattern spec such as "checkerboard 1deg contrast 50%".
+func Parse(spec string) (Pattern, error) {
+	tokens := specToken.FindAllString(strings.ToLower(spec), -1)
+	if len(tokens) == 0 {
+		return Pattern{}, fmt.Errorf("empty pattern, expected %s", strings.Join(kindNames[:], ", "))
+	}
+	kind := -1
+	for i, name := range kindNames {
+		if tokens[0] == name {
+			kind = i
+		}
+	}
+	if kind < 0 {
+		return Pattern{}, fmt.Errorf("unknown pattern %q, expected %s", tokens[0], strings.Join(kindNames[:], ", "))
+	}
+	p := Default(Kind(kind))
+	tokens = tokens[1:]
+
+	next := func() string {
+		if len(tokens) == 0 {
+			return ""
+		}
+		t := tokens[0]
+		tokens = tokens[1:]
+		return t
+	}
+	number := func(what string) (float64, error) {
+		t := next()
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected %s, found %q", what, t)
+		}
+		return v, nil
+	}
+	percent := func(what string) (float64, error) {
+		v, err := number(what)
+		if err != nil {
+			return 0, err
+		}
+		if t := next(); t != "%" {
+			return 0, fmt.Errorf(`expected "%%" after the %s, found %q`, what, t)
+		}
+		if v < 0 || v > 100 {
+			return 0, fmt.Errorf("%s must be between 0%% and 100%%", what)
+		}
+		return v / 100, nil
+	}
+
+	// An optional size comes first
+	if len(tokens) > 0 {
+		if size, err := strconv.ParseFloat(tokens[0], 64); err == nil {
+			next()
+			switch next() {
+			case "px":
+				p.Unit = Pixels
+			case "deg":
+				p.Unit = Degrees
+			default:
+				return Pattern{}, fmt.Errorf("expected size unit px or deg after %g", size)
+			}
+			if !(size > 0) {
+				return Pattern{}, fmt.Errorf("size must be positive")
+			}
+			if p.Kind == Solid {
+				return Pattern{}, fmt.Errorf("a solid field has no size")
+			}
+			p.Size = size
+		}
+	}
+	for len(tokens) > 0 {
+		var err error
+		switch t := next(); {
+		case t == "phases":
+			var n float64
+			n, err = number("number of phases")
+			if err == nil && (n < 2 || n > 16 || n != float64(int(n))) {
+				err = fmt.Errorf("phases must be a whole number from 2 to 16")
+			}
+			p.Phases = int(n)
+		case t == "contrast":
+			p.Contrast, err = percent("contrast")
+		case t == "mean":
+			p.Mean, err = percent("mean luminance")
+		case t == "wedges" && p.Kind == Radial:
+			var n float64
+			n, err = number("number of wedges")
+			if err == nil && (n < 2 || n != float64(int(n)) || int(n)%2 != 0) {
+				err = fmt.Errorf("wedges must be an even whole number of at least 2")
+			}
+			p.Wedges = int(n)
+		case t == "angle" && p.Kind == Grating:
+			p.Angle, err = number("grating angle in degrees")
+		case t == "square" && p.Kind == Grating:
+			p.Square = true
+		case t == "sine" && p.Kind == Grating:
+			p.Square = false
+		default:
+			err = fmt.Errorf("unexpected %q for a %s pattern", t, p.Kind)
+		}
+		if err != nil {
+			return Pattern{}, err
+		}
+	}
+	if p.Mean*(1+p.Contrast) > 1+1e-9 {
+		return Pattern{}, fmt.Errorf("contrast %s%% at mean %s%% is brighter than the display can show",
+			formatNumber(p.Contrast*100), formatNumber(p.Mean*100))
+	}
+	return p, nil
+}
+
+// String writes the spec of the pattern, which Parse reads back.
+func (p Pattern) String() string {
+	parts := []string{p.Kind.String()}
+	if p.Kind != Solid {
+		parts = append(parts, formatNumber(p.Size)+p.Unit.String())
+	}
+	if p.Kind == Radial {
+		parts = append(parts, "wedges", strconv.Itoa(p.Wedges))
+	}
+	if p.Kind == Grating {
+		if p.Angle != 0 {
+			parts = append(parts, "angle", formatNumber(p.Angle))
+		}
+		if p.Square {
+			parts = append(parts, "square")
+		}
+	}
+	if p.Phases != 2 {
+		parts = append(parts, "phases", strconv.Itoa(p.Phases))
+	}
+	if p.Contrast != 1 {
+		parts = append(parts, "contrast", formatNumber(p.Contrast*100)+"%")
+	}
+	if p.Mean != 0.5 {
+		parts = append(parts, "mean", formatNumber(p.Mean*100)+"%")
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
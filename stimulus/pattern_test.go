@@ -0,0 +1,82 @@
+package stimulus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Pattern
+	}{
+		{"checkerboard", Pattern{Kind: Checkerboard, Size: 64, Contrast: 1, Mean: 0.5, Phases: 2}},
+		{"Checkerboard 0.5deg contrast 50%", Pattern{Kind: Checkerboard, Size: 0.5, Unit: Degrees, Contrast: 0.5, Mean: 0.5, Phases: 2}},
+		{"checkerboard 32 px", Pattern{Kind: Checkerboard, Size: 32, Contrast: 1, Mean: 0.5, Phases: 2}},
+		{"radial 1deg wedges 24", Pattern{Kind: Radial, Size: 1, Unit: Degrees, Contrast: 1, Mean: 0.5, Wedges: 24, Phases: 2}},
+		{"grating 2deg angle 45 square", Pattern{Kind: Grating, Size: 2, Unit: Degrees, Contrast: 1, Mean: 0.5, Angle: 45, Square: true, Phases: 2}},
+		{"grating square sine", Pattern{Kind: Grating, Size: 128, Contrast: 1, Mean: 0.5, Phases: 2}},
+		{"grating 1deg phases 4", Pattern{Kind: Grating, Size: 1, Unit: Degrees, Contrast: 1, Mean: 0.5, Phases: 4}},
+		{"solid mean 30%", Pattern{Kind: Solid, Size: 64, Contrast: 1, Mean: 0.3, Phases: 2}},
+		{"solid contrast 0% mean 100%", Pattern{Kind: Solid, Size: 64, Mean: 1, Phases: 2}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.spec)
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec, want string
+	}{
+		{"", "empty pattern"},
+		{"stripes 8px", `unknown pattern "stripes"`},
+		{"checkerboard 8", "expected size unit px or deg after 8"},
+		{"checkerboard 8cm", "expected size unit px or deg after 8"},
+		{"checkerboard 0px", "size must be positive"},
+		{"checkerboard -4px", "size must be positive"},
+		{"solid 8px", "a solid field has no size"},
+		{"checkerboard contrast", `expected contrast, found ""`},
+		{"checkerboard contrast 50", `expected "%" after the contrast`},
+		{"checkerboard contrast 150%", "contrast must be between 0% and 100%"},
+		{"solid mean -10%", "mean luminance must be between 0% and 100%"},
+		{"grating phases 1", "phases must be a whole number from 2 to 16"},
+		{"grating phases 2.5", "phases must be a whole number from 2 to 16"},
+		{"grating phases 17", "phases must be a whole number from 2 to 16"},
+		{"radial wedges 7", "wedges must be an even whole number"},
+		{"radial wedges x", `expected number of wedges, found "x"`},
+		{"checkerboard wedges 8", `unexpected "wedges" for a checkerboard pattern`},
+		{"radial angle 45", `unexpected "angle" for a radial pattern`},
+		{"checkerboard square", `unexpected "square" for a checkerboard pattern`},
+		{"checkerboard mean 60%", "contrast 100% at mean 60% is brighter than the display can show"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.spec); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q): %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestPatternString(t *testing.T) {
+	tests := []struct {
+		p    Pattern
+		want string
+	}{
+		{Default(Checkerboard), "checkerboard 64px"},
+		{Default(Radial), "radial 64px wedges 16"},
+		{Default(Solid), "solid"},
+		{Pattern{Kind: Grating, Size: 0.5, Unit: Degrees, Contrast: 0.25, Mean: 0.4, Angle: 90, Square: true, Phases: 8},
+			"grating 0.5deg angle 90 square phases 8 contrast 25% mean 40%"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.String(); got != tt.want {
+			t.Errorf("%+v written as %q, want %q", tt.p, got, tt.want)
+		}
+		if back, err := Parse(tt.want); err != nil || back != tt.p {
+			t.Errorf("%q read back as %+v, %v", tt.want, back, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package stimulus
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Viewing is the geometry needed to convert degrees of visual angle to
+// pixels.
+type Viewing struct {
+	DistanceCM  float64 `json:"distance_cm,omitempty"`   // eye to screen
+	PixelsPerCM float64 `json:"pixels_per_cm,omitempty"` // pixel density of the display
+}
+
+// PixelsPerDegree returns how many pixels one degree of visual angle spans
+// at the centre of the screen.
+func (v Viewing) PixelsPerDegree() (float64, error) {
+	if !(v.DistanceCM > 0) || !(v.PixelsPerCM > 0) {
+		return 0, errors.New("sizes in degrees need the viewing distance and the pixels per cm of the display")
+	}
+	return 2 * v.DistanceCM * math.Tan(math.Pi/360) * v.PixelsPerCM, nil
+}
+
+// Pixels returns the size of the pattern in pixels.
+func (p Pattern) Pixels(v Viewing) (float64, error) {
+	if p.Unit == Pixels {
+		return p.Size, nil
+	}
+	ppd, err := v.PixelsPerDegree()
+	return p.Size * ppd, err
+}
+
+// Render draws the phases of the pattern at size, centred so radial
+// patterns and the checks meet in the middle of the area. Each phase moves
+// the pattern by an equal share of its cycle, so the second of two phases
+// is the first with its contrast reversed.
+func (p Pattern) Render(size image.Point, v Viewing) ([]*image.RGBA, error) {
+	px, err := p.Pixels(v)
+	if err != nil {
+		return nil, err
+	}
+	if px < 1 {
+		px = 1
+	}
+	levels := p.levels()
+	frames := make([]*image.RGBA, p.Phases)
+	for k := range frames {
+		frames[k] = image.NewRGBA(image.Rectangle{Max: size})
+	}
+	cx, cy := float64(size.X)/2, float64(size.Y)/2
+	sin, cos := math.Sincos(p.Angle * math.Pi / 180)
+	for y := 0; y < size.Y; y++ {
+		dy := float64(y) + 0.5 - cy
+		for x := 0; x < size.X; x++ {
+			dx := float64(x) + 0.5 - cx
+			i := y*frames[0].Stride + x*4
+			for k, frame := range frames {
+				shift := float64(k) / float64(p.Phases) // share of a cycle
+				var s float64                           // -1 to 1, the contrast of this pixel
+				switch p.Kind {
+				case Checkerboard:
+					// A cycle is two checks wide
+					s = parity(int(math.Floor(dx/px-2*shift)) + int(math.Floor(dy/px)))
+				case Radial:
+					r := math.Hypot(dx, dy)
+					wedge := (math.Atan2(dy, dx) + math.Pi) / (2 * math.Pi) * float64(p.Wedges)
+					s = parity(int(r/px) + int(math.Floor(wedge-2*shift)))
+				case Grating:
+					s = math.Cos(2 * math.Pi * ((dx*cos+dy*sin)/px - shift))
+					if p.Square {
+						s = math.Copysign(1, s)
+					}
+				case Solid:
+					s = math.Cos(2 * math.Pi * shift)
+				}
+				l := levels[int(math.Round((s+1)/2*float64(len(levels)-1)))]
+				frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2], frame.Pix[i+3] = l, l, l, 255
+			}
+		}
+	}
+	return frames, nil
+}
+
+// Fill returns a uniform field of color c.
+func Fill(size image.Point, c color.NRGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+// MeanGray returns the sRGB value of the mean luminance of the pattern,
+// the grey that matches it when seen from a distance.
+func (p Pattern) MeanGray() uint8 {
+	return encodeSRGB(p.Mean)
+}
+
+// levels maps contrast -1 to 1 onto sRGB values. Luminance is linear in the
+// contrast, so the pair keeps the mean luminance of the pattern.
+func (p Pattern) levels() []uint8 {
+	levels := make([]uint8, 1025)
+	for i := range levels {
+		s := float64(i)/float64(len(levels)-1)*2 - 1
+		levels[i] = encodeSRGB(p.Mean * (1 + p.Contrast*s))
+	}
+	return levels
+}
+
+func parity(n int) float64 {
+	if n&1 == 0 {
+		return 1
+	}
+	return -1
+}
+
+// encodeSRGB converts a linear luminance of 0 to 1 to an 8-bit sRGB value.
+func encodeSRGB(l float64) uint8 {
+	l = math.Max(0, math.Min(1, l))
+	if l <= 0.0031308 {
+		l *= 12.92
+	} else {
+		l = 1.055*math.Pow(l, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(l * 255))
+}
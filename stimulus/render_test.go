@@ -0,0 +1,186 @@
+package stimulus
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestEncodeSRGB(t *testing.T) {
+	tests := []struct {
+		l    float64
+		want uint8
+	}{
+		{-1, 0},
+		{0, 0},
+		{0.002, 7}, // the linear segment
+		{0.18, 118},
+		{0.2, 124},
+		{0.5, 188},
+		{1, 255},
+		{2, 255},
+	}
+	for _, tt := range tests {
+		if got := encodeSRGB(tt.l); got != tt.want {
+			t.Errorf("encodeSRGB(%g) = %d, want %d", tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		spec            string
+		low, mean, high uint8
+	}{
+		{"checkerboard", 0, 188, 255},
+		{"checkerboard contrast 50%", 137, 188, 225},
+		{"solid contrast 0%", 188, 188, 188},
+		{"solid mean 20% contrast 100%", 0, 124, 170},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		levels := p.levels()
+		low, mean, high := levels[0], levels[len(levels)/2], levels[len(levels)-1]
+		if low != tt.low || mean != tt.mean || high != tt.high {
+			t.Errorf("%s: levels %d, %d, %d, want %d, %d, %d", tt.spec, low, mean, high, tt.low, tt.mean, tt.high)
+		}
+		if mean != p.MeanGray() {
+			t.Errorf("%s: mean level %d, mean gray %d", tt.spec, mean, p.MeanGray())
+		}
+	}
+}
+
+// gray returns the value of the pixel at x, y of a grey image.
+func gray(img *image.RGBA, x, y int) uint8 {
+	return img.Pix[img.PixOffset(x, y)]
+}
+
+// linear converts an 8-bit sRGB value to linear luminance.
+func linear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func TestRenderCheckerboard(t *testing.T) {
+	p, err := Parse("checkerboard 2px")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := p.Render(image.Pt(8, 6), Viewing{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("%d frames, want 2", len(frames))
+	}
+	// The checks meet in the middle at 4, 3
+	want := []string{
+		"##..##..",
+		"..##..##",
+		"..##..##",
+		"##..##..",
+		"##..##..",
+		"..##..##",
+	}
+	for y, row := range want {
+		for x := range row {
+			on, off := uint8(255), uint8(0)
+			if row[x] == '.' {
+				on, off = off, on
+			}
+			if got := gray(frames[0], x, y); got != on {
+				t.Errorf("%d, %d is %d, want %d", x, y, got, on)
+			}
+			if got := gray(frames[1], x, y); got != off {
+				t.Errorf("%d, %d of the second phase is %d, want %d", x, y, got, off)
+			}
+			if a := frames[0].Pix[frames[0].PixOffset(x, y)+3]; a != 255 {
+				t.Errorf("%d, %d has alpha %d", x, y, a)
+			}
+		}
+	}
+}
+
+func TestRenderContrast(t *testing.T) {
+	// The phases of a grating at any contrast average to the mean luminance
+	for _, spec := range []string{"grating 8px", "grating 8px contrast 30%", "grating 8px mean 25% contrast 80% square"} {
+		p, err := Parse(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames, err := p.Render(image.Pt(16, 1), Viewing{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x := range 16 {
+			a, b := linear(gray(frames[0], x, 0)), linear(gray(frames[1], x, 0))
+			if mean := (a + b) / 2; math.Abs(mean-p.Mean) > 0.01 {
+				t.Errorf("%s: %d averages %g, want %g", spec, x, mean, p.Mean)
+			}
+			if c := math.Abs(a-b) / (a + b); c > p.Contrast+0.01 {
+				t.Errorf("%s: %d has contrast %g, want at most %g", spec, x, c, p.Contrast)
+			}
+		}
+	}
+}
+
+func TestRenderPhases(t *testing.T) {
+	p, err := Parse("grating 4px phases 4 square")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := p.Render(image.Pt(8, 2), Viewing{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("%d frames, want 4", len(frames))
+	}
+	// Each phase moves the bars a pixel, a quarter of the period
+	for k := 1; k < 4; k++ {
+		for x := 1; x < 8; x++ {
+			if a, b := gray(frames[k], x, 0), gray(frames[k-1], x-1, 0); a != b {
+				t.Errorf("phase %d at %d is %d, phase %d at %d is %d", k, x, a, k-1, x-1, b)
+			}
+		}
+	}
+
+	// A solid field alternates between the extremes
+	p, _ = Parse("solid mean 20%")
+	frames, err = p.Render(image.Pt(2, 2), Viewing{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := gray(frames[0], 1, 1), gray(frames[1], 1, 1); a != 170 || b != 0 {
+		t.Errorf("solid field %d and %d, want 170 and 0", a, b)
+	}
+}
+
+func TestRenderDegrees(t *testing.T) {
+	p, err := Parse("checkerboard 1deg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Render(image.Pt(4, 4), Viewing{}); err == nil {
+		t.Error("rendered degrees without the viewing distance")
+	}
+	// At 57.3cm one degree is about 1cm
+	v := Viewing{DistanceCM: 57.3, PixelsPerCM: 2}
+	px, err := p.Pixels(v)
+	if err != nil || math.Abs(px-2) > 0.01 {
+		t.Errorf("1deg is %g px, %v, want 2", px, err)
+	}
+	frames, err := p.Render(image.Pt(4, 4), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := gray(frames[0], 0, 0), gray(frames[0], 2, 0); a == b {
+		t.Errorf("checks 2px apart are both %d", a)
+	}
+}
@@ -0,0 +1,144 @@
+package stimulus
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Source is what a schedule step shows instead of the session stimulus:
+// image files, a generated pattern or uniform colors, shown in turn. A
+// single image or color is shown steadily. Exactly one field is set.
+type Source struct {
+	Images  []string `json:"images,omitempty" yaml:"images,omitempty"`
+	Pattern string   `json:"pattern,omitempty" yaml:"pattern,omitempty"` // pattern spec such as "checkerboard 1deg"
+	Colors  []string `json:"color,omitempty" yaml:"color,omitempty"`     // colors such as "#808080"
+}
+
+// Validate checks that exactly one kind of stimulus is given and that it
+// can be read.
+func (s Source) Validate() error {
+	set := 0
+	if s.Images != nil {
+		set++
+	}
+	if s.Pattern != "" {
+		set++
+	}
+	if s.Colors != nil {
+		set++
+	}
+	if set != 1 {
+		return errors.New("expected one of images, pattern or color")
+	}
+	switch {
+	case s.Images != nil:
+		if len(s.Images) == 0 {
+			return errors.New("no image files")
+		}
+		for _, path := range s.Images {
+			if strings.TrimSpace(path) == "" {
+				return errors.New("empty image file name")
+			}
+		}
+	case s.Pattern != "":
+		if _, err := Parse(s.Pattern); err != nil {
+			return err
+		}
+	default:
+		if len(s.Colors) == 0 {
+			return errors.New("no colors")
+		}
+		for _, c := range s.Colors {
+			if _, err := ParseColor(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Frames returns the number of frames the source shows in turn.
+func (s Source) Frames() int {
+	switch {
+	case s.Images != nil:
+		return len(s.Images)
+	case s.Pattern != "":
+		p, err := Parse(s.Pattern)
+		if err != nil {
+			return 0
+		}
+		return p.Phases
+	}
+	return len(s.Colors)
+}
+
+// String writes the source in the notation of the schedule language, e.g.
+// `pattern "checkerboard 1deg"` or `images "a.png" "b.png"`, which
+// ParseSource reads back.
+func (s Source) String() string {
+	keyword, values := "color", s.Colors
+	switch {
+	case s.Images != nil:
+		keyword, values = "images", s.Images
+	case s.Pattern != "":
+		keyword, values = "pattern", []string{s.Pattern}
+	}
+	parts := []string{keyword}
+	for _, v := range values {
+		parts = append(parts, strconv.Quote(v))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseSource reads a source written by String.
+func ParseSource(text string) (Source, error) {
+	keyword, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+	var values []string
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return Source{}, fmt.Errorf("expected a quoted string, found %q", rest)
+		}
+		v, _ := strconv.Unquote(quoted)
+		values = append(values, v)
+		rest = rest[len(quoted):]
+	}
+	s, err := NewSource(keyword, values)
+	if err != nil {
+		return Source{}, err
+	}
+	return s, s.Validate()
+}
+
+// NewSource returns the source of kind "images", "pattern" or "color"
+// with the given values.
+func NewSource(kind string, values []string) (Source, error) {
+	switch strings.ToLower(kind) {
+	case "images":
+		return Source{Images: values}, nil
+	case "pattern":
+		if len(values) != 1 {
+			return Source{}, errors.New("a pattern is a single spec such as \"checkerboard 1deg\"")
+		}
+		return Source{Pattern: values[0]}, nil
+	case "color":
+		return Source{Colors: values}, nil
+	}
+	return Source{}, fmt.Errorf("unknown stimulus %q, expected images, pattern or color", kind)
+}
+
+// ParseColor reads a color written as "#rgb" or "#rrggbb".
+func ParseColor(text string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(text), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 || !strings.HasPrefix(strings.TrimSpace(text), "#") {
+		return color.NRGBA{}, fmt.Errorf("expected a color such as #808080, found %q", text)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}